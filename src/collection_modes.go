@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// collectionMode tells how the metrics of an entity type are collected
+type collectionMode string
+
+const (
+	// modePerf collects performance counters, decorated with the summary attributes of the entity
+	modePerf collectionMode = "perf"
+	// modeSummary collects the metrics of the entity summary without querying performance counters
+	modeSummary collectionMode = "summary"
+	// modeBoth reports both the performance counter and the summary samples of the entity
+	modeBoth collectionMode = "both"
+	// modeOff collects nothing for the entity type
+	modeOff collectionMode = "off"
+)
+
+// perf tells whether performance counters are collected
+func (m collectionMode) perf() bool {
+	return m == modePerf || m == modeBoth
+}
+
+// summary tells whether summary metrics are collected
+func (m collectionMode) summary() bool {
+	return m == modeSummary || m == modeBoth
+}
+
+func parseCollectionMode(mode string) (collectionMode, error) {
+	switch m := collectionMode(strings.ToLower(strings.TrimSpace(mode))); m {
+	case modePerf, modeSummary, modeBoth, modeOff:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown collection mode %q, expected perf, summary, both or off", mode)
+}
+
+// collectionModes are the collection modes of the config file for each entity type
+type collectionModes struct {
+	Host                   string `json:"host" yaml:"host"`
+	VM                     string `json:"vm" yaml:"vm"`
+	ResourcePool           string `json:"resourcePool" yaml:"resourcePool"`
+	ClusterComputeResource string `json:"clusterComputeResource" yaml:"clusterComputeResource"`
+	Datastore              string `json:"datastore" yaml:"datastore"`
+}
+
+// resolveCollectionModes sets the collection mode of each entity type from its argument, the modes of the config
+// file or, when neither is set, the deprecated --source_config bitmask, whose bits select performance counters
+// over the summary.
+func resolveCollectionModes(configured collectionModes) error {
+	for _, entityType := range []struct {
+		name       string
+		argument   string
+		configured string
+		legacyBit  int
+		mode       *collectionMode
+	}{
+		{"host", args.HostMode, configured.Host, bitHostSystemPerfMetrics, &hostMode},
+		{"vm", args.VMMode, configured.VM, bitVirtualMachinePerfMetrics, &vmMode},
+		{"resource pool", args.ResourcePoolMode, configured.ResourcePool, bitResourcePoolPerfMetrics, &rpoolMode},
+		{"datastore", args.DatastoreMode, configured.Datastore, bitDatastorePerfMetrics, &dsMode},
+		{"cluster", args.ClusterMode, configured.ClusterComputeResource, bitClusterPerfMetrics, &clMode},
+	} {
+		value := entityType.argument
+		if value == "" {
+			value = entityType.configured
+		}
+		if value == "" {
+			*entityType.mode = modeSummary
+			if (args.SourceConfig & entityType.legacyBit) != 0 {
+				*entityType.mode = modePerf
+			}
+			continue
+		}
+		mode, err := parseCollectionMode(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s collection mode: %v", entityType.name, err)
+		}
+		*entityType.mode = mode
+	}
+	return nil
+}
+
+// entityTypeMode returns the collection mode of a managed object type. Types without a mode, such as datacenters, are always collected.
+func entityTypeMode(moType string) collectionMode {
+	switch moType {
+	case "HostSystem":
+		return hostMode
+	case "VirtualMachine":
+		return vmMode
+	case "ResourcePool":
+		return rpoolMode
+	case "Datastore":
+		return dsMode
+	case "ClusterComputeResource":
+		return clMode
+	}
+	return modeBoth
+}
+
+// perfCounterLists returns the counter lists of the entity types collected from performance counters
+func perfCounterLists() [][]string {
+	counterLists := make([][]string, 0, len(configEntityTypes))
+	for _, entityType := range configEntityTypes {
+		if entityType.mode.perf() {
+			counterLists = append(counterLists, *entityType.counters)
+		}
+	}
+	return counterLists
+}
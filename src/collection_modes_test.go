@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCollectionMode(t *testing.T) {
+	mode, err := parseCollectionMode(" Both ")
+	assert.NoError(t, err)
+	assert.Equal(t, modeBoth, mode)
+	assert.True(t, mode.perf())
+	assert.True(t, mode.summary())
+
+	mode, err = parseCollectionMode("off")
+	assert.NoError(t, err)
+	assert.False(t, mode.perf())
+	assert.False(t, mode.summary())
+
+	_, err = parseCollectionMode("all")
+	assert.Error(t, err)
+}
+
+func TestResolveCollectionModes(t *testing.T) {
+	defer func(saved argumentList) {
+		args = saved
+	}(args)
+
+	args = argumentList{SourceConfig: bitHostSystemPerfMetrics | bitResourcePoolPerfMetrics, VMMode: "off"}
+	err := resolveCollectionModes(collectionModes{VM: "perf", Datastore: "both"})
+	assert.NoError(t, err)
+	assert.Equal(t, modePerf, hostMode)
+	assert.Equal(t, modeOff, vmMode)
+	assert.Equal(t, modePerf, rpoolMode)
+	assert.Equal(t, modeBoth, dsMode)
+	assert.Equal(t, modeSummary, clMode)
+	assert.Equal(t, modeOff, entityTypeMode("VirtualMachine"))
+	assert.Equal(t, modeBoth, entityTypeMode("Datacenter"))
+
+	err = resolveCollectionModes(collectionModes{Host: "performance"})
+	assert.Error(t, err)
+}
@@ -2,17 +2,18 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"os"
+	"sync"
 
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 )
 
-func populateMetricsAndInventory(i *integration.Integration, client *govmomi.Client, datacenter string) error {
+func populateMetricsAndInventory(i *integration.Integration, client *govmomi.Client, tags *tagClient, datacenter string) error {
 	all := true
 	finder := find.NewFinder(client.Client, all)
 
@@ -22,50 +23,83 @@ func populateMetricsAndInventory(i *integration.Integration, client *govmomi.Cli
 		if err != nil {
 			return err
 		}
-		populateMetricsAndInventoryForDC(i, client, dc)
+		populateMetricsAndInventoryForDC(i, client, tags, dc)
 	} else if datacenter == "all" {
 		dclist, err := finder.DatacenterList(context.Background(), "*")
 		if err != nil {
 			return err
 		}
 		for _, dc := range dclist {
-			populateMetricsAndInventoryForDC(i, client, dc)
+			populateMetricsAndInventoryForDC(i, client, tags, dc)
 		}
 	} else {
 		dc, err := finder.Datacenter(context.Background(), datacenter)
 		if err != nil {
 			return err
 		}
-		populateMetricsAndInventoryForDC(i, client, dc)
+		populateMetricsAndInventoryForDC(i, client, tags, dc)
 	}
 	return nil
 }
 
-func populateMetricsAndInventoryForDC(integration *integration.Integration, client *govmomi.Client, dc *object.Datacenter) {
+func populateMetricsAndInventoryForDC(integration *integration.Integration, client *govmomi.Client, tags *tagClient, dc *object.Datacenter) {
 	// Create datacenter Entity
-	entity, err := integration.Entity("datacenter", dc.Name())
+	entities, err := newEntityResolver(integration, dc.Name())
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(4)
 	}
 
-	/*
-		if args.All() || args.Events {
-			log.Info("populating inventory for datacenter [%s]", dc.Name())
-			//TODO
-			setupEvents(client, dc)
+	if args.All() || args.Events {
+		log.Info("populating events for datacenter [%s]", dc.Name())
+		store, err := newStore(client, "events", storeTTL)
+		if err != nil {
+			log.Error("unable to open event checkpoint store: %v", err)
+		} else {
+			eventCollector := &eventCollector{
+				client: client,
+				entity: entities.datacenter,
+				dc:     dc,
+				store:  store,
+			}
+			err = eventCollector.collect()
+			if err != nil {
+				log.Error("failed to collect events: %v", err)
+			}
+		}
+	}
+
+	// labels decorate the samples and are read by tag filters, which also apply to inventory
+	var labels *entityLabels
+	var selection *entitySelection
+	if args.All() || args.Metrics || (args.Inventory && configuredFilters.configured()) {
+		labels, err = collectEntityLabels(client, dc, tags)
+		if err != nil {
+			log.Error("unable to retrieve custom attributes: %v", err)
+		}
 
+		selection, err = selectEntities(client, dc, labels)
+		if err != nil {
+			// collecting every object would report the objects the filters exclude
+			log.Error("unable to apply entity filters, entity types with filters are not collected: %v", err)
+			selection = configuredFilters.selectNone()
 		}
-	*/
+	}
 
 	if args.All() || args.Inventory {
 		log.Info("populating inventory for datacenter [%s]", dc.Name())
-		//TODO
-		hostMetrics, err := populateInventory(client, dc)
-		if err != nil {
-			log.Error(err.Error())
+		if hostMode != modeOff {
+			err = populateHostInventory(entities, client, dc, selection)
+			if err != nil {
+				log.Error("failed to collect Host System inventory: %v", err)
+			}
+		}
+		if vmMode != modeOff {
+			err = populateVMInventory(entities, client, dc, selection)
+			if err != nil {
+				log.Error("failed to collect Virtual Machine inventory: %v", err)
+			}
 		}
-		fmt.Println(hostMetrics)
 	}
 
 	if args.All() || args.Metrics {
@@ -73,9 +107,11 @@ func populateMetricsAndInventoryForDC(integration *integration.Integration, clie
 
 		//init summary collector
 		summaryCollector := &summaryCollector{
-			client: client,
-			entity: entity,
-			dc:     dc,
+			client:    client,
+			entities:  entities,
+			dc:        dc,
+			labels:    labels,
+			selection: selection,
 		}
 
 		//init performance collector
@@ -83,69 +119,152 @@ func populateMetricsAndInventoryForDC(integration *integration.Integration, clie
 		// Make future calls local to this datacenter
 		finder.SetDatacenter(dc)
 
-		summaryMetrics, err := collectDatastoreSummaryAttributes(client, dc)
-		if err != nil {
-			log.Error(err.Error())
+		summaryMetrics := make(map[string]map[string]interface{})
+		if dsMode.perf() {
+			dsSummaryMetrics, err := collectDatastoreSummaryAttributes(client, dc)
+			if err != nil {
+				log.Error(err.Error())
+			}
+			for ref, attributes := range dsSummaryMetrics {
+				summaryMetrics[ref] = attributes
+			}
+		}
+		if clMode.perf() {
+			clSummaryMetrics, err := collectClusterSummaryAttributes(client, dc)
+			if err != nil {
+				log.Error(err.Error())
+			}
+			for ref, attributes := range clSummaryMetrics {
+				summaryMetrics[ref] = attributes
+			}
+		}
+		if hostMode.perf() {
+			hsSummaryMetrics, err := collectHostSummaryAttributes(client, dc)
+			if err != nil {
+				log.Error(err.Error())
+			}
+			for ref, attributes := range hsSummaryMetrics {
+				summaryMetrics[ref] = attributes
+			}
+		}
+		if vmMode.perf() {
+			vmSummaryMetrics, err := collectVMSummaryAttributes(client, dc)
+			if err != nil {
+				log.Error(err.Error())
+			}
+			for ref, attributes := range vmSummaryMetrics {
+				summaryMetrics[ref] = attributes
+			}
+		}
+		var perfStore persist.Storer
+		if args.AggregateSamples {
+			perfStore, err = newStore(client, "perf", storeTTL)
+			if err != nil {
+				log.Error("unable to open performance sample store: %v", err)
+			}
 		}
 		perfCollector := &perfCollector{
 			client:         client,
-			entity:         entity,
+			entities:       entities,
 			finder:         finder,
 			summaryMetrics: summaryMetrics,
-			metricFilter:   "*",
+			labels:         labels,
+			selection:      selection,
+			queries:        newWorkerPool(args.Concurrency),
+			store:          perfStore,
 		}
 
-		err = perfCollector.initCounterMetadata()
+		err = perfCollector.initCounterMetadata(perfCounterLists()...)
 		if err != nil {
 			log.Error(err.Error())
 			os.Exit(5)
 		}
+		logAvailableCounters()
 
-		if enableHostSystemPerfMetrics {
-			err = perfCollector.collect("Host System", "ESXHostSystemSample", hostCounters)
-			if err != nil {
-				log.Error("failed to collect Host System metrics: %v", err)
+		// entity types are collected in parallel, performance queries share the pool of the perf collector
+		var wg sync.WaitGroup
+		pool := newWorkerPool(args.Concurrency)
+		pool.run(&wg, func() {
+			if hostMode.perf() {
+				err := perfCollector.collect("Host System", "ESXHostSystemSample", hostCounters)
+				if err != nil {
+					log.Error("failed to collect Host System metrics: %v", err)
+				}
 			}
-		} else {
-			err = summaryCollector.collectHostMetrics("ESXHostSystemSample")
-			if err != nil {
-				log.Error("failed to collect Host System metrics: %v", err)
+			if hostMode.summary() {
+				err := summaryCollector.collectHostMetrics("ESXHostSystemSample")
+				if err != nil {
+					log.Error("failed to collect Host System summary metrics: %v", err)
+				}
 			}
-		}
-
-		if enableVirtualMachinePerfMetrics {
-			err = perfCollector.collect("Virtual Machine", "ESXVirtualMachineSample", vmCounters)
-			if err != nil {
-				log.Error("failed to collect Virtual Machine metrics: %v", err)
+		})
+		pool.run(&wg, func() {
+			if vmMode.perf() {
+				err := perfCollector.collect("Virtual Machine", "ESXVirtualMachineSample", vmCounters)
+				if err != nil {
+					log.Error("failed to collect Virtual Machine metrics: %v", err)
+				}
 			}
-		} else {
-			err = summaryCollector.collectVMMetrics("ESXVirtualMachineSample")
-			if err != nil {
-				log.Error("failed to collect Virtual Machine metrics: %v", err)
+			if vmMode.summary() {
+				err := summaryCollector.collectVMMetrics("ESXVirtualMachineSample")
+				if err != nil {
+					log.Error("failed to collect Virtual Machine summary metrics: %v", err)
+				}
 			}
-		}
-
-		if enableResourcePoolPerfMetrics {
-			err = perfCollector.collect("Resource Pool", "ESXResourcePoolSample", rpoolCounters)
-			if err != nil {
-				log.Error("failed to collect Resource Pool metrics: %v", err)
+		})
+		pool.run(&wg, func() {
+			if rpoolMode.perf() {
+				err := perfCollector.collect("Resource Pool", "ESXResourcePoolSample", rpoolCounters)
+				if err != nil {
+					log.Error("failed to collect Resource Pool metrics: %v", err)
+				}
 			}
-		} else {
-			err = summaryCollector.collectResourcePoolMetrics("ESXResourcePoolSample")
-			if err != nil {
-				log.Error("failed to collect Resource Pool metrics: %v", err)
+			if rpoolMode.summary() {
+				err := summaryCollector.collectResourcePoolMetrics("ESXResourcePoolSample")
+				if err != nil {
+					log.Error("failed to collect Resource Pool summary metrics: %v", err)
+				}
+			}
+		})
+		pool.run(&wg, func() {
+			if dsMode.perf() {
+				err := perfCollector.collect("Datastore", "ESXDatastoreSample", dsCounters)
+				if err != nil {
+					log.Error("failed to collect Datastore metrics: %v", err)
+				}
+			}
+			if dsMode.summary() {
+				err := summaryCollector.collectDSMetrics("ESXDatastoreSample")
+				if err != nil {
+					log.Error("failed to collect Datastore summary metrics: %v", err)
+				}
 			}
+		})
+		pool.run(&wg, func() {
+			if clMode.perf() {
+				err := perfCollector.collect("Cluster Compute Resource", "ESXClusterSample", clCounters)
+				if err != nil {
+					log.Error("failed to collect Cluster Compute Resource metrics: %v", err)
+				}
+			}
+			if clMode.summary() {
+				err := summaryCollector.collectClusterMetrics("ESXClusterSample")
+				if err != nil {
+					log.Error("failed to collect Cluster Compute Resource summary metrics: %v", err)
+				}
+			}
+		})
+		wg.Wait()
+
+		err = summaryCollector.collectAlarms("ESXAlarmSample")
+		if err != nil {
+			log.Error("failed to collect triggered alarms: %v", err)
 		}
 
-		if enableDatastorePerfMetrics {
-			err = perfCollector.collect("Datastore", "ESXDatastoreSample", dsCounters)
-			if err != nil {
-				log.Error("failed to collect Datastore metrics: %v", err)
-			}
-		} else {
-			err = summaryCollector.collectDSMetrics("ESXDatastoreSample")
+		if perfStore != nil {
+			err = perfStore.Save()
 			if err != nil {
-				log.Error("failed to collect Datastore metrics: %v", err)
+				log.Error("unable to save performance sample store: %v", err)
 			}
 		}
 	}
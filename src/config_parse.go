@@ -1,19 +1,111 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/newrelic/infra-integrations-sdk/log"
+	yaml "gopkg.in/yaml.v2"
 )
 
+// metricDefinitions are the counters configured for each entity type. Entity types that are
+// not present in the config file keep the built-in defaults of metrics_definition.go.
 type metricDefinitions struct {
-	Host                   []string
-	VM                     []string
-	ResourcePool           []string
-	ClusterComputeResource []string
-	Datastore              []string
+	Host                   *counterRules `json:"host" yaml:"host"`
+	VM                     *counterRules `json:"vm" yaml:"vm"`
+	ResourcePool           *counterRules `json:"resourcePool" yaml:"resourcePool"`
+	ClusterComputeResource *counterRules `json:"clusterComputeResource" yaml:"clusterComputeResource"`
+	Datastore              *counterRules `json:"datastore" yaml:"datastore"`
+
+	Filters entityFilters   `json:"filters" yaml:"filters"`
+	Modes   collectionModes `json:"modes" yaml:"modes"`
+}
+
+// counterRules are the counters of an entity type. They are either a list of counters, which
+// replaces the built-in defaults, or include and exclude lists applied to the defaults:
+//
+//	host:
+//	  include: [cpu.ready.summation]
+//	  exclude: ["disk.*"]
+//
+// With inherit: false the include list replaces the defaults instead.
+type counterRules struct {
+	Include []string `json:"include" yaml:"include"`
+	Exclude []string `json:"exclude" yaml:"exclude"`
+	Inherit *bool    `json:"inherit" yaml:"inherit"`
+
+	// list holds the counters when the rules are a plain list
+	list   []string
+	isList bool
+}
+
+// counters returns the counter list of the rules applied to defaults. Excluded counters are
+// prefixed with "!", which resolveCounters removes from the counters matched by the other entries.
+func (r *counterRules) counters(defaults []string) []string {
+	if r == nil {
+		return defaults
+	}
+	if r.isList {
+		return r.list
+	}
+	counters := make([]string, 0)
+	if r.Inherit == nil || *r.Inherit {
+		counters = append(counters, defaults...)
+	}
+	counters = append(counters, r.Include...)
+	for _, exclude := range r.Exclude {
+		counters = append(counters, "!"+exclude)
+	}
+	return counters
+}
+
+// UnmarshalYAML reads the rules from either a list of counters or an include/exclude mapping
+func (r *counterRules) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*r = counterRules{list: list, isList: true}
+		return nil
+	}
+	type rules counterRules
+	return unmarshal((*rules)(r))
+}
+
+// counterRulesError is an error in the rules of an entity type, read from the JSON document raw
+type counterRulesError struct {
+	raw    []byte
+	offset int64
+	err    error
+}
+
+func (e *counterRulesError) Error() string {
+	return e.err.Error()
+}
+
+// UnmarshalJSON reads the rules from either a list of counters or an include/exclude object
+func (r *counterRules) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*r = counterRules{list: list, isList: true}
+		return nil
+	}
+	type rules counterRules
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode((*rules)(r))
+	if err != nil {
+		var offset int64
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			offset = typeErr.Offset
+		}
+		return &counterRulesError{raw: data, offset: offset, err: err}
+	}
+	return nil
 }
 
 func fileExists(filePath string) (exists bool) {
@@ -26,16 +118,24 @@ func fileExists(filePath string) (exists bool) {
 	return
 }
 
+// loadConfiguration reads a JSON or YAML config file. Files named *.json, or whose content starts
+// with "{" when the extension is neither .json, .yml nor .yaml, are read as JSON.
 func loadConfiguration(file string) (metricDefinitions, error) {
 	var metricDefinitions metricDefinitions
-	configFile, err := os.Open(file)
-	defer close(configFile)
+	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		log.Error("Error reading configuration file '%s': %v", file, err)
 		return metricDefinitions, err
 	}
-	jsonParser := json.NewDecoder(configFile)
-	err = jsonParser.Decode(&metricDefinitions)
+
+	if isJSONConfig(file, data) {
+		err = decodeJSONConfig(data, &metricDefinitions)
+	} else {
+		err = yaml.UnmarshalStrict(data, &metricDefinitions)
+	}
+	if err == nil {
+		err = metricDefinitions.checkCounterPatterns(data)
+	}
 	if err != nil {
 		log.Error("Error reading configuration file '%s': %v", file, err)
 		return metricDefinitions, err
@@ -43,6 +143,84 @@ func loadConfiguration(file string) (metricDefinitions, error) {
 	return metricDefinitions, nil
 }
 
+// checkCounterPatterns returns an error for the first invalid glob pattern or regular expression of the counter
+// lists, prefixed with the line of the config file data it is found at
+func (d *metricDefinitions) checkCounterPatterns(data []byte) error {
+	for _, rules := range []*counterRules{d.Host, d.VM, d.ResourcePool, d.ClusterComputeResource, d.Datastore} {
+		for _, entry := range rules.counters(nil) {
+			entry = strings.TrimPrefix(strings.TrimSpace(entry), "!")
+			if _, err := compileCounterPattern(entry); err != nil {
+				if offset := entryOffset(data, entry); offset >= 0 {
+					return fmt.Errorf("line %d: %v", lineAt(data, offset), err)
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entryOffset returns the offset of a counter list entry in the config file data, written as is or JSON escaped, or -1
+func entryOffset(data []byte, entry string) int64 {
+	escaped, _ := json.Marshal(entry)
+	for _, written := range [][]byte{[]byte(entry), escaped[1 : len(escaped)-1]} {
+		if offset := bytes.Index(data, written); offset >= 0 {
+			return int64(offset)
+		}
+	}
+	return -1
+}
+
+func isJSONConfig(file string, data []byte) bool {
+	switch filepath.Ext(file) {
+	case ".json":
+		return true
+	case ".yml", ".yaml":
+		return false
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+var unknownFieldPattern = regexp.MustCompile(`unknown field (".*")`)
+
+// decodeJSONConfig decodes a JSON config file, prefixing errors with the line they occur at
+func decodeJSONConfig(data []byte, metricDefinitions *metricDefinitions) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(metricDefinitions)
+	if err == nil {
+		return nil
+	}
+
+	offset := int64(-1)
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	case *counterRulesError:
+		if start := bytes.Index(data, e.raw); start >= 0 {
+			offset = int64(start) + e.offset
+		}
+	default:
+		if match := unknownFieldPattern.FindStringSubmatch(err.Error()); match != nil {
+			offset = int64(bytes.Index(data, []byte(match[1])))
+		}
+	}
+	if offset < 0 {
+		return err
+	}
+	return fmt.Errorf("line %d: %v", lineAt(data, offset), err)
+}
+
+// lineAt returns the line number of the byte at offset in data
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
 func parseConfigFile(configFile string) error {
 	log.Info(fmt.Sprintf("Reading configuration file %s", configFile))
 
@@ -53,12 +231,25 @@ func parseConfigFile(configFile string) error {
 	if err != nil {
 		return fmt.Errorf("Error loading configuration from file. Default metric configuration will be used. (%v)", err)
 	}
-	hostCounters = metricDef.Host
+	hostCounters = metricDef.Host.counters(defaultHostCounters)
 	log.Debug("Host metrics from configuration = %v", hostCounters)
-	vmCounters = metricDef.VM
+	vmCounters = metricDef.VM.counters(defaultVMCounters)
 	log.Debug("VM metrics from configuration= %v", vmCounters)
-	rpoolCounters = metricDef.ResourcePool
+	rpoolCounters = metricDef.ResourcePool.counters(defaultResourcePoolCounters)
 	log.Debug("Resource Pool metrics from configuration= %v", rpoolCounters)
+	clCounters = metricDef.ClusterComputeResource.counters(defaultClusterCounters)
+	log.Debug("Cluster Compute Resource metrics from configuration= %v", clCounters)
+	dsCounters = metricDef.Datastore.counters(defaultDatastoreCounters)
+	log.Debug("Datastore metrics from configuration= %v", dsCounters)
+
+	for moType, filter := range metricDef.Filters.byType() {
+		err = filter.compile(moType)
+		if err != nil {
+			return fmt.Errorf("Error loading configuration from file. Invalid %s filter: %v", moType, err)
+		}
+	}
+	configuredFilters = metricDef.Filters
+	configuredModes = metricDef.Modes
 
 	return nil
 }
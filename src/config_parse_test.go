@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "nri-vmware-esxi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	err = ioutil.WriteFile(file, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadConfigurationJSON(t *testing.T) {
+	file := writeConfig(t, "config.json", `{
+	"host": ["cpu.usage.average"],
+	"vm": {"include": ["cpu.ready.summation"], "exclude": ["mem.*"]}
+}`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	metricDef, err := loadConfiguration(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu.usage.average"}, metricDef.Host.counters(defaultHostCounters))
+	assert.Equal(t, []string{"cpu.usage.average", "cpu.ready.summation", "!mem.*"}, metricDef.VM.counters([]string{"cpu.usage.average"}))
+	assert.Equal(t, []string{"cpu.usage.average"}, metricDef.ResourcePool.counters([]string{"cpu.usage.average"}))
+}
+
+func TestLoadConfigurationYAML(t *testing.T) {
+	file := writeConfig(t, "config.yml", `
+host:
+  - cpu.usage.average
+vm:
+  inherit: false
+  include:
+    - cpu.ready.summation
+datastore:
+  exclude: ["disk.*"]
+modes:
+  vm: "off"
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	metricDef, err := loadConfiguration(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu.usage.average"}, metricDef.Host.counters(defaultHostCounters))
+	assert.Equal(t, []string{"cpu.ready.summation"}, metricDef.VM.counters(defaultVMCounters))
+	assert.Equal(t, []string{"datastore.read.average", "!disk.*"}, metricDef.Datastore.counters([]string{"datastore.read.average"}))
+	assert.Nil(t, metricDef.ClusterComputeResource)
+	assert.Equal(t, collectionModes{VM: "off"}, metricDef.Modes)
+}
+
+func TestLoadConfigurationErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		content       string
+		expectedError string
+	}{
+		{"syntax.json", "{\n\t\"host\": [\n\t\t\"cpu.usage.average\"\n\t\n}", "line 5: "},
+		{"type.json", "{\n\t\"host\": [\"cpu.usage.average\"],\n\t\"vm\": {\"include\": 5}\n}", "line 3: "},
+		{"field.json", "{\n\t\"host\": [\"cpu.usage.average\"],\n\t\"hosts\": []\n}", "line 3: "},
+		{"field.yml", "host:\n  - cpu.usage.average\nvm:\n  includes: [cpu.ready.summation]\n", "line 4: "},
+		{"regexp.json", "{\n\t\"host\": [\"cpu.usage.average\"],\n\t\"vm\": [\"/^net\\\\.(received/\"]\n}", "line 3: invalid counter expression /^net\\.(received/"},
+		{"glob.yml", "host:\n  include:\n    - cpu.usage.average\n  exclude:\n    - \"disk.[a-\"\n", "line 5: invalid counter pattern disk.[a-"},
+	}
+
+	for _, tc := range testCases {
+		file := writeConfig(t, tc.name, tc.content)
+		_, err := loadConfiguration(file)
+		os.RemoveAll(filepath.Dir(file))
+		if assert.Error(t, err, tc.name) {
+			assert.Contains(t, err.Error(), tc.expectedError, tc.name)
+		}
+	}
+}
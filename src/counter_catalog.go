@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// counterCatalog is the list of performance counters and historical intervals of a vCenter, as read from
+// its PerformanceManager. The catalog holds hundreds of counters, so it is cached on disk between runs and
+// in memory across datacenters.
+type counterCatalog struct {
+	Counters            []counterMetadata    `json:"counters"`
+	HistoricalIntervals []types.PerfInterval `json:"historicalIntervals"`
+
+	// retrieved is set when the catalog was read from vCenter during this run rather than from the cache
+	retrieved bool
+}
+
+// loadedCatalog is the catalog loaded during this run
+var loadedCatalog *counterCatalog
+
+// loggedCatalog is the catalog whose counters were logged with --log_available_counters
+var loggedCatalog *counterCatalog
+
+// logAvailableCounters logs the counters of the catalog loaded during this run with --log_available_counters,
+// once however many datacenters are collected
+func logAvailableCounters() {
+	if !args.LogAvailableCounters || loadedCatalog == nil || loadedCatalog == loggedCatalog {
+		return
+	}
+	loggedCatalog = loadedCatalog
+	log.Info("%d performance counters are available", len(loadedCatalog.Counters))
+	for _, counter := range loadedCatalog.Counters {
+		log.Info("\t%s [%d] %s %s", counter.Name, counter.Level, counter.Unit, counter.StatsType)
+	}
+}
+
+// loadCounterCatalog returns the counter catalog of the vCenter client is connected to. Unless refresh is set
+// the catalog loaded earlier in the run or cached on disk less than --counter_cache_ttl minutes ago is used.
+func loadCounterCatalog(client *govmomi.Client, refresh bool) (*counterCatalog, error) {
+	if loadedCatalog != nil && (!refresh || loadedCatalog.retrieved) {
+		return loadedCatalog, nil
+	}
+
+	ttl := time.Duration(args.CounterCacheTTL) * time.Minute
+	key := counterCatalogKey(client)
+	if !refresh && ttl > 0 {
+		catalog, err := readCachedCatalog(client, key, ttl)
+		if err != nil {
+			log.Warn("unable to read the cached counter catalog: %v", err)
+		} else if catalog != nil {
+			log.Debug("using the cached counter catalog %s", key)
+			loadedCatalog = catalog
+			return catalog, nil
+		}
+	}
+
+	catalog, err := retrieveCounterCatalog(client)
+	if err != nil {
+		return nil, err
+	}
+	loadedCatalog = catalog
+
+	if ttl > 0 {
+		store, err := newStore(client, "counters", ttl)
+		if err == nil {
+			store.Set(key, catalog)
+			err = store.Save()
+		}
+		if err != nil {
+			log.Warn("unable to cache the counter catalog: %v", err)
+		}
+	}
+	return catalog, nil
+}
+
+// refreshCounterCatalogAfterMiss refreshes a cached catalog in which the counters missing were not found. The
+// refresh is recorded in the cache, so that counters the vCenter does not provide, such as a misspelled counter,
+// do not refresh the catalog again before --counter_cache_ttl minutes have passed.
+func refreshCounterCatalogAfterMiss(client *govmomi.Client, missing []string) (*counterCatalog, error) {
+	ttl := time.Duration(args.CounterCacheTTL) * time.Minute
+	key := refreshedAfterMissKey(client)
+	var refreshed time.Time
+	if store, err := newStore(client, "counters", ttl); err == nil {
+		if _, err = store.Get(key, &refreshed); err == nil {
+			log.Debug("counters %v are missing from the counter catalog refreshed at %v", missing, refreshed)
+			return loadedCatalog, nil
+		}
+	}
+
+	log.Debug("refreshing the cached counter catalog, counters %v are missing", missing)
+	catalog, err := loadCounterCatalog(client, true)
+	if err != nil {
+		return nil, err
+	}
+	// the store is opened again as loadCounterCatalog saved the refreshed catalog to it
+	store, err := newStore(client, "counters", ttl)
+	if err == nil {
+		store.Set(key, time.Now())
+		err = store.Save()
+	}
+	if err != nil {
+		log.Warn("unable to record the counter catalog refresh: %v", err)
+	}
+	return catalog, nil
+}
+
+func refreshedAfterMissKey(client *govmomi.Client) string {
+	return "refreshedAfterMiss." + counterCatalogKey(client)
+}
+
+// counterCatalogKey identifies the catalog of a vCenter instance and API version, which determine the counters it provides
+func counterCatalogKey(client *govmomi.Client) string {
+	about := client.ServiceContent.About
+	return fmt.Sprintf("catalog.%s.%s", about.InstanceUuid, about.ApiVersion)
+}
+
+// readCachedCatalog returns the catalog cached under key, or nil when there is none
+func readCachedCatalog(client *govmomi.Client, key string, ttl time.Duration) (*counterCatalog, error) {
+	store, err := newStore(client, "counters", ttl)
+	if err != nil {
+		return nil, err
+	}
+	var catalog counterCatalog
+	_, err = store.Get(key, &catalog)
+	if err != nil || len(catalog.Counters) == 0 {
+		// nothing cached yet or the cached catalog expired
+		return nil, nil
+	}
+	return &catalog, nil
+}
+
+func retrieveCounterCatalog(client *govmomi.Client) (*counterCatalog, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var perfManager mo.PerformanceManager
+	err := client.RetrieveOne(ctx, *client.ServiceContent.PerfManager, []string{"perfCounter", "historicalInterval"}, &perfManager)
+	if err != nil {
+		log.Error("Could not retrieve performance manager")
+		return nil, err
+	}
+
+	catalog := &counterCatalog{
+		Counters:            make([]counterMetadata, 0, len(perfManager.PerfCounter)),
+		HistoricalIntervals: perfManager.HistoricalInterval,
+		retrieved:           true,
+	}
+	for _, perfCounter := range perfManager.PerfCounter {
+		groupInfo := perfCounter.GroupInfo.GetElementDescription()
+		nameInfo := perfCounter.NameInfo.GetElementDescription()
+		catalog.Counters = append(catalog.Counters, counterMetadata{
+			Key:       perfCounter.Key,
+			Name:      groupInfo.Key + "." + nameInfo.Key + "." + fmt.Sprint(perfCounter.RollupType),
+			Unit:      perfCounter.UnitInfo.GetElementDescription().Key,
+			Rollup:    string(perfCounter.RollupType),
+			StatsType: string(perfCounter.StatsType),
+			Level:     perfCounter.Level,
+		})
+	}
+	return catalog, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// counterMetadata is the description of a performance counter kept from the PerformanceManager catalog
+type counterMetadata struct {
+	Key  int32  `json:"key"`
+	Name string `json:"name"`
+	// Unit is the key of the counter's UnitInfo, e.g. percent, kiloBytes or megaHertz
+	Unit string `json:"unit"`
+	// Rollup is the rollup type of the counter, e.g. average, summation or latest
+	Rollup string `json:"rollup"`
+	// StatsType tells whether the counter value is absolute, a delta over the sampling interval or a rate
+	StatsType string `json:"statsType"`
+	// Level is the statistics level from which vCenter collects the counter
+	Level int32 `json:"level"`
+}
+
+// normalizeValue returns the metric name a counter value is reported as and the value. With --normalize_units
+// the value is scaled to its base unit and the name of scaled counters carries the unit they are reported in:
+// percentages are reported in the 0-100 range instead of hundredths of a percent, sizes in bytes and throughputs
+// in bytes per second. Frequencies are not scaled but named explicitly. Otherwise counters keep their name and
+// raw value.
+func normalizeValue(counter counterMetadata, value int64) (string, float64) {
+	if !args.NormalizeUnits {
+		return counter.Name, float64(value)
+	}
+	switch counter.Unit {
+	case "percent":
+		return counter.Name + ".percent", float64(value) / 100
+	case "kiloBytes":
+		return counter.Name + ".bytes", float64(value) * (1 << 10)
+	case "megaBytes":
+		return counter.Name + ".bytes", float64(value) * (1 << 20)
+	case "teraBytes":
+		return counter.Name + ".bytes", float64(value) * (1 << 40)
+	case "kiloBytesPerSecond":
+		return counter.Name + ".bytesPerSecond", float64(value) * (1 << 10)
+	case "megaBytesPerSecond":
+		return counter.Name + ".bytesPerSecond", float64(value) * (1 << 20)
+	case "megaHertz":
+		return counter.Name + ".mhz", float64(value)
+	}
+	return counter.Name, float64(value)
+}
+
+// missingSample is the value of the samples of a series for which the counter has no value
+const missingSample = -1
+
+// seriesValues returns the values of a counter series indexed by the metric name they are reported as, which
+// normalizeValue scales to their base unit with --normalize_units. Samples without a value, which vCenter reports
+// as -1, are left out. Summation counters measured in milliseconds, such as cpu.ready.summation, accumulate time
+// over each sampling interval; they are also reported as the percentage of the interval they represent,
+// <name>.percent, and when the number of cpus the value is accumulated over is known, as the percentage per
+// cpu, <name>.percentPerCpu.
+func seriesValues(counter counterMetadata, series []int64, sampleInfo []types.PerfSampleInfo, cpus int32) map[string][]float64 {
+	values := make(map[string][]float64)
+	for _, v := range series {
+		if v == missingSample {
+			continue
+		}
+		metricName, value := normalizeValue(counter, v)
+		values[metricName] = append(values[metricName], value)
+	}
+
+	isTimeSummation := counter.Unit == "millisecond" && strings.HasSuffix(counter.Name, ".summation")
+	if !isTimeSummation || len(sampleInfo) != len(series) {
+		return values
+	}
+	for i, v := range series {
+		if v == missingSample || sampleInfo[i].Interval <= 0 {
+			continue
+		}
+		percent := float64(v) / (float64(sampleInfo[i].Interval) * 1000) * 100
+		values[counter.Name+".percent"] = append(values[counter.Name+".percent"], percent)
+		if cpus > 0 {
+			values[counter.Name+".percentPerCpu"] = append(values[counter.Name+".percentPerCpu"], percent/float64(cpus))
+		}
+	}
+	return values
+}
+
+// summarizeValues returns the average, minimum and maximum of a non-empty series of values
+func summarizeValues(values []float64) (avg float64, min float64, max float64) {
+	min, max = values[0], values[0]
+	var sum float64
+	for _, v := range values {
+		sum += v
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return sum / float64(len(values)), min, max
+}
+
+// resolveCounters resolves counter names and patterns against the counter catalog, indexed by full counter
+// name. Entries are either full counter names (cpu.usage.average), glob patterns (cpu.*.average, disk.*)
+// or regular expressions enclosed in slashes (/^mem\.(active|consumed)\./). Entries prefixed with "!"
+// exclude the counters they match from the ones matched by the other entries. It returns the keys of the
+// matching counters and the entries that matched no counter.
+func resolveCounters(catalog map[string]int32, counterList []string) ([]int32, []string) {
+	counterIDs := make([]int32, 0)
+	missingCounters := make([]string, 0)
+	resolved := make(map[int32]bool)
+
+	excluded := make(map[int32]bool)
+	for _, entry := range counterList {
+		entry = strings.TrimSpace(entry)
+		if strings.HasPrefix(entry, "!") {
+			for _, name := range matchCounters(catalog, entry[1:]) {
+				excluded[catalog[name]] = true
+			}
+		}
+	}
+
+	for _, entry := range counterList {
+		entry = strings.TrimSpace(entry)
+		if strings.HasPrefix(entry, "!") {
+			continue
+		}
+		matches := matchCounters(catalog, entry)
+		if len(matches) == 0 {
+			missingCounters = append(missingCounters, entry)
+			continue
+		}
+		for _, name := range matches {
+			counterID := catalog[name]
+			if !resolved[counterID] && !excluded[counterID] {
+				resolved[counterID] = true
+				counterIDs = append(counterIDs, counterID)
+			}
+		}
+	}
+	return counterIDs, missingCounters
+}
+
+// matchCounters returns the sorted names of the counters of the catalog an entry of a counter list matches.
+// Invalid patterns, which the config file is checked for when it is loaded, match no counter.
+func matchCounters(catalog map[string]int32, entry string) []string {
+	matches := make([]string, 0)
+
+	match, err := compileCounterPattern(entry)
+	if err != nil {
+		return matches
+	}
+	for name := range catalog {
+		if match(name) {
+			matches = append(matches, name)
+		}
+	}
+
+	sort.Strings(matches)
+	return matches
+}
+
+// compileCounterPattern returns the function matching counter names against an entry of a counter list: a regular
+// expression enclosed in slashes, a glob pattern or a counter name
+func compileCounterPattern(entry string) (func(name string) bool, error) {
+	switch {
+	case len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/"):
+		re, err := regexp.Compile(entry[1 : len(entry)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid counter expression %s: %v", entry, err)
+		}
+		return re.MatchString, nil
+	case strings.ContainsAny(entry, "*?["):
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, fmt.Errorf("invalid counter pattern %s: %v", entry, err)
+		}
+		return func(name string) bool {
+			matched, _ := path.Match(entry, name)
+			return matched
+		}, nil
+	}
+	return func(name string) bool {
+		return name == entry
+	}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// withNormalizedUnits runs test with --normalize_units set
+func withNormalizedUnits(test func()) {
+	defer func(saved bool) {
+		args.NormalizeUnits = saved
+	}(args.NormalizeUnits)
+	args.NormalizeUnits = true
+	test()
+}
+
+func TestNormalizeValue(t *testing.T) {
+	testCases := []struct {
+		counter       counterMetadata
+		value         int64
+		expectedName  string
+		expectedValue float64
+	}{
+		{counterMetadata{Name: "cpu.usage.average", Unit: "percent"}, 4250, "cpu.usage.average.percent", 42.5},
+		{counterMetadata{Name: "mem.consumed.average", Unit: "kiloBytes"}, 2, "mem.consumed.average.bytes", 2048},
+		{counterMetadata{Name: "disk.provisioned.latest", Unit: "megaBytes"}, 1, "disk.provisioned.latest.bytes", 1 << 20},
+		{counterMetadata{Name: "net.usage.average", Unit: "kiloBytesPerSecond"}, 10, "net.usage.average.bytesPerSecond", 10240},
+		{counterMetadata{Name: "cpu.usagemhz.average", Unit: "megaHertz"}, 2400, "cpu.usagemhz.average.mhz", 2400},
+		{counterMetadata{Name: "cpu.ready.summation", Unit: "millisecond"}, 150, "cpu.ready.summation", 150},
+	}
+
+	withNormalizedUnits(func() {
+		for _, tc := range testCases {
+			name, value := normalizeValue(tc.counter, tc.value)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedValue, value)
+		}
+	})
+
+	// without --normalize_units counters keep their name and raw value
+	for _, tc := range testCases {
+		name, value := normalizeValue(tc.counter, tc.value)
+		assert.Equal(t, tc.counter.Name, name)
+		assert.Equal(t, float64(tc.value), value)
+	}
+}
+
+func TestSummarizeValues(t *testing.T) {
+	avg, min, max := summarizeValues([]float64{4, 1, 7})
+	assert.Equal(t, 4.0, avg)
+	assert.Equal(t, 1.0, min)
+	assert.Equal(t, 7.0, max)
+
+	avg, min, max = summarizeValues([]float64{3})
+	assert.Equal(t, 3.0, avg)
+	assert.Equal(t, 3.0, min)
+	assert.Equal(t, 3.0, max)
+}
+
+func TestResolveCounters(t *testing.T) {
+	catalog := map[string]int32{
+		"cpu.usage.average":    1,
+		"cpu.usage.maximum":    2,
+		"cpu.ready.summation":  3,
+		"cpu.demand.average":   4,
+		"mem.active.average":   5,
+		"mem.consumed.average": 6,
+		"mem.granted.average":  7,
+		"disk.read.average":    8,
+		"disk.usage.average":   9,
+	}
+
+	testCases := []struct {
+		counterList     []string
+		expectedIDs     []int32
+		expectedMissing []string
+	}{
+		{[]string{"cpu.usage.average", "cpu.unknown.average"}, []int32{1}, []string{"cpu.unknown.average"}},
+		{[]string{"cpu.*.average"}, []int32{4, 1}, []string{}},
+		{[]string{"disk.*"}, []int32{8, 9}, []string{}},
+		{[]string{`/^mem\.(active|consumed)\./`}, []int32{5, 6}, []string{}},
+		{[]string{"cpu.usage.average", "cpu.usage.*"}, []int32{1, 2}, []string{}},
+		{[]string{"net.*", "/(/"}, []int32{}, []string{"net.*", "/(/"}},
+		{[]string{"cpu.*", "!cpu.usage.*", "!net.*"}, []int32{4, 3}, []string{}},
+		{[]string{"!mem.active.average", `/^mem\./`}, []int32{6, 7}, []string{}},
+	}
+
+	for _, tc := range testCases {
+		ids, missing := resolveCounters(catalog, tc.counterList)
+		assert.Equal(t, tc.expectedIDs, ids, "%v", tc.counterList)
+		assert.Equal(t, tc.expectedMissing, missing, "%v", tc.counterList)
+	}
+}
+
+func TestSeriesValues(t *testing.T) {
+	sampleInfo := []types.PerfSampleInfo{{Interval: 20}, {Interval: 20}}
+
+	ready := counterMetadata{Name: "cpu.ready.summation", Unit: "millisecond"}
+	values := seriesValues(ready, []int64{400, 1000}, sampleInfo, 2)
+	assert.Equal(t, map[string][]float64{
+		"cpu.ready.summation":               {400, 1000},
+		"cpu.ready.summation.percent":       {2, 5},
+		"cpu.ready.summation.percentPerCpu": {1, 2.5},
+	}, values)
+
+	values = seriesValues(ready, []int64{400, 1000}, sampleInfo, 0)
+	assert.Equal(t, map[string][]float64{
+		"cpu.ready.summation":         {400, 1000},
+		"cpu.ready.summation.percent": {2, 5},
+	}, values)
+
+	usage := counterMetadata{Name: "cpu.usage.average", Unit: "percent"}
+	values = seriesValues(usage, []int64{1250, 2500}, sampleInfo, 2)
+	assert.Equal(t, map[string][]float64{
+		"cpu.usage.average": {1250, 2500},
+	}, values)
+
+	used := counterMetadata{Name: "disk.used.latest", Unit: "kiloBytes"}
+	historicalSampleInfo := []types.PerfSampleInfo{{Interval: 300}, {Interval: 300}, {Interval: 300}}
+	withNormalizedUnits(func() {
+		values = seriesValues(usage, []int64{1250, 2500}, sampleInfo, 2)
+		assert.Equal(t, map[string][]float64{
+			"cpu.usage.average.percent": {12.5, 25},
+		}, values)
+
+		values = seriesValues(used, []int64{-1, 2, -1}, historicalSampleInfo, 0)
+		assert.Equal(t, map[string][]float64{
+			"disk.used.latest.bytes": {2048},
+		}, values)
+	})
+
+	values = seriesValues(ready, []int64{-1, 1000}, sampleInfo, 0)
+	assert.Equal(t, map[string][]float64{
+		"cpu.ready.summation":         {1000},
+		"cpu.ready.summation.percent": {5},
+	}, values)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// entityNamespaces are the SDK entity namespaces of the managed object types reported as their own entity
+var entityNamespaces = map[string]string{
+	"HostSystem":             "esx-host",
+	"VirtualMachine":         "esx-vm",
+	"Datastore":              "esx-datastore",
+	"ResourcePool":           "esx-resourcepool",
+	"ClusterComputeResource": "esx-cluster",
+}
+
+// entityResolver decides which SDK entity the samples and inventory of a managed object belong to.
+// By default everything is reported on the datacenter entity; with --entity_per_object each
+// host, vm, datastore, resource pool and cluster becomes its own entity. It is safe for concurrent use.
+// Entities are keyed by the object name, so objects of a type that share a name, such as the root
+// "Resources" pool of every cluster, are reported on the same entity.
+type entityResolver struct {
+	integration *integration.Integration
+	datacenter  *integration.Entity
+	dcName      string
+
+	// mutex serializes the creation of entities and metric sets, which the SDK does not synchronize
+	mutex sync.Mutex
+}
+
+func newEntityResolver(i *integration.Integration, dcName string) (*entityResolver, error) {
+	datacenter, err := i.Entity("datacenter", dcName)
+	if err != nil {
+		return nil, err
+	}
+	return &entityResolver{
+		integration: i,
+		datacenter:  datacenter,
+		dcName:      dcName,
+	}, nil
+}
+
+// entity returns the SDK entity of the managed object ref named name
+func (r *entityResolver) entity(ref types.ManagedObjectReference, name string) *integration.Entity {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lockedEntity(ref, name)
+}
+
+func (r *entityResolver) lockedEntity(ref types.ManagedObjectReference, name string) *integration.Entity {
+	namespace, ok := entityNamespaces[ref.Type]
+	if !args.EntityPerObject || !ok {
+		return r.datacenter
+	}
+	entity, err := r.integration.Entity(name, namespace)
+	if err != nil {
+		log.Error("unable to create entity %s:%s: %v", namespace, name, err)
+		return r.datacenter
+	}
+	return entity
+}
+
+// newMetricSet creates a metric set for the managed object ref named name. The attributes identify the
+// metric set among the ones of the same event type on the entity.
+func (r *entityResolver) newMetricSet(nrEventType string, ref types.ManagedObjectReference, name string, attributes ...metric.Attribute) *metric.Set {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entity := r.lockedEntity(ref, name)
+	ms := entity.NewMetricSet(nrEventType, attributes...)
+	if entity != r.datacenter {
+		err := ms.SetMetric("datacenter", r.dcName, metric.ATTRIBUTE)
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}
+	return ms
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/vmware/govmomi/event"
+	"github.com/vmware/govmomi/object"
+
+	sdkEvent "github.com/newrelic/infra-integrations-sdk/data/event"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// eventPageSize is the number of events read from the history collector per call
+const eventPageSize = 100
+
+// collectedEventTypes are the vSphere event types published as integration events
+var collectedEventTypes = []string{
+	// host connectivity
+	"HostConnectedEvent",
+	"HostConnectionLostEvent",
+	"HostDisconnectedEvent",
+	"HostShutdownEvent",
+	"EnteredMaintenanceModeEvent",
+	"ExitMaintenanceModeEvent",
+	// vm power state
+	"VmPoweredOnEvent",
+	"VmPoweredOffEvent",
+	"VmSuspendedEvent",
+	"VmResettingEvent",
+	"VmGuestRebootEvent",
+	"VmGuestShutdownEvent",
+	// migrations
+	"VmMigratedEvent",
+	"DrsVmMigratedEvent",
+	"VmRelocatedEvent",
+	"VmFailedMigrateEvent",
+	// alarms
+	"AlarmStatusChangedEvent",
+}
+
+// eventCheckpoint is the last event published for a datacenter
+type eventCheckpoint struct {
+	Key         int32
+	CreatedTime time.Time
+}
+
+type eventCollector struct {
+	client *govmomi.Client
+	entity *integration.Entity
+	dc     *object.Datacenter
+	store  persist.Storer
+}
+
+func (c *eventCollector) checkpointKey() string {
+	return "events." + c.dc.Name()
+}
+
+func (c *eventCollector) collect() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	checkpoint, seeded := c.loadCheckpoint()
+
+	manager := event.NewManager(c.client.Client)
+	collector, err := manager.CreateCollectorForEvents(ctx, c.eventFilter(checkpoint, seeded))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := collector.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	if !seeded {
+		// First run: remember where the event history ends without replaying it
+		latest, err := collector.LatestPage(ctx)
+		if err != nil {
+			return err
+		}
+		now, err := methods.GetCurrentTime(ctx, c.client)
+		if err != nil {
+			return err
+		}
+		checkpoint = initialCheckpoint(latest, *now)
+		log.Info("no event checkpoint for datacenter [%s], starting after event %d", c.dc.Name(), checkpoint.Key)
+		c.store.Set(c.checkpointKey(), checkpoint)
+		return c.store.Save()
+	}
+
+	err = collector.Rewind(ctx)
+	if err != nil {
+		return err
+	}
+
+	published := 0
+	last := checkpoint
+	for {
+		events, err := collector.ReadNextEvents(ctx, eventPageSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, e := range events {
+			be := e.GetEvent()
+			if be.Key <= checkpoint.Key {
+				continue
+			}
+			err = c.entity.AddEvent(sdkEvent.New(be.FullFormattedMessage, eventTypeName(e)))
+			if err != nil {
+				log.Error(err.Error())
+				continue
+			}
+			published++
+			last = advanceCheckpoint(last, be)
+		}
+	}
+	log.Debug("published %d events for datacenter [%s]", published, c.dc.Name())
+
+	c.store.Set(c.checkpointKey(), last)
+	return c.store.Save()
+}
+
+// loadCheckpoint returns the checkpoint of the datacenter and whether there is one. A checkpoint without time,
+// as saved by earlier versions when the event history was empty, would replay the whole history and is ignored.
+func (c *eventCollector) loadCheckpoint() (eventCheckpoint, bool) {
+	var checkpoint eventCheckpoint
+	_, err := c.store.Get(c.checkpointKey(), &checkpoint)
+	if err != nil || checkpoint.CreatedTime.IsZero() {
+		return eventCheckpoint{}, false
+	}
+	return checkpoint, true
+}
+
+// eventFilter returns the filter of the collected events of the datacenter, created after checkpoint when seeded
+func (c *eventCollector) eventFilter(checkpoint eventCheckpoint, seeded bool) types.EventFilterSpec {
+	filter := types.EventFilterSpec{
+		Entity: &types.EventFilterSpecByEntity{
+			Entity:    c.dc.Reference(),
+			Recursion: types.EventFilterSpecRecursionOptionAll,
+		},
+		EventTypeId: collectedEventTypes,
+	}
+	if seeded {
+		filter.Time = &types.EventFilterSpecByTime{BeginTime: &checkpoint.CreatedTime}
+	}
+	return filter
+}
+
+// initialCheckpoint returns the checkpoint of the latest events of the history, or of the current server time
+// now when there are none, so that the next run only publishes events that occur after it
+func initialCheckpoint(latest []types.BaseEvent, now time.Time) eventCheckpoint {
+	checkpoint := eventCheckpoint{CreatedTime: now}
+	for _, e := range latest {
+		checkpoint = advanceCheckpoint(checkpoint, e.GetEvent())
+	}
+	return checkpoint
+}
+
+// advanceCheckpoint returns the checkpoint of e when it is newer than checkpoint
+func advanceCheckpoint(checkpoint eventCheckpoint, e *types.Event) eventCheckpoint {
+	if e.Key > checkpoint.Key {
+		return eventCheckpoint{Key: e.Key, CreatedTime: e.CreatedTime}
+	}
+	return checkpoint
+}
+
+// eventTypeName returns the vSphere type name of an event, e.g. VmPoweredOnEvent
+func eventTypeName(e types.BaseEvent) string {
+	t := reflect.TypeOf(e)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestAdvanceCheckpoint(t *testing.T) {
+	created := time.Date(2019, 1, 10, 12, 0, 0, 0, time.UTC)
+	checkpoint := eventCheckpoint{Key: 10, CreatedTime: created}
+
+	newer := &types.Event{Key: 12, CreatedTime: created.Add(time.Minute)}
+	assert.Equal(t, eventCheckpoint{Key: 12, CreatedTime: created.Add(time.Minute)}, advanceCheckpoint(checkpoint, newer))
+
+	older := &types.Event{Key: 8, CreatedTime: created.Add(-time.Minute)}
+	assert.Equal(t, checkpoint, advanceCheckpoint(checkpoint, older))
+}
+
+func TestInitialCheckpoint(t *testing.T) {
+	now := time.Date(2019, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	// an empty history starts at the current server time rather than at the beginning of the history
+	assert.Equal(t, eventCheckpoint{CreatedTime: now}, initialCheckpoint(nil, now))
+
+	latest := []types.BaseEvent{
+		&types.VmPoweredOnEvent{VmEvent: types.VmEvent{Event: types.Event{Key: 41, CreatedTime: now.Add(-2 * time.Minute)}}},
+		&types.VmPoweredOffEvent{VmEvent: types.VmEvent{Event: types.Event{Key: 42, CreatedTime: now.Add(-time.Minute)}}},
+	}
+	assert.Equal(t, eventCheckpoint{Key: 42, CreatedTime: now.Add(-time.Minute)}, initialCheckpoint(latest, now))
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	dc := object.NewDatacenter(nil, types.ManagedObjectReference{Type: "Datacenter", Value: "datacenter-2"})
+	dc.InventoryPath = "/DC1"
+	c := &eventCollector{dc: dc, store: persist.NewInMemoryStore()}
+
+	// first run: the history is not replayed and no time filter applies
+	checkpoint, seeded := c.loadCheckpoint()
+	assert.False(t, seeded)
+	assert.Nil(t, c.eventFilter(checkpoint, seeded).Time)
+
+	// a checkpoint without time is not trusted
+	c.store.Set(c.checkpointKey(), eventCheckpoint{})
+	_, seeded = c.loadCheckpoint()
+	assert.False(t, seeded)
+
+	created := time.Date(2019, 1, 10, 12, 0, 0, 0, time.UTC)
+	c.store.Set(c.checkpointKey(), eventCheckpoint{Key: 42, CreatedTime: created})
+	checkpoint, seeded = c.loadCheckpoint()
+	assert.True(t, seeded)
+	assert.Equal(t, int32(42), checkpoint.Key)
+	filter := c.eventFilter(checkpoint, seeded)
+	if assert.NotNil(t, filter.Time) {
+		assert.True(t, created.Equal(*filter.Time.BeginTime))
+	}
+	assert.Equal(t, dc.Reference(), filter.Entity.Entity)
+}
+
+func TestEventTypeName(t *testing.T) {
+	assert.Equal(t, "VmPoweredOnEvent", eventTypeName(&types.VmPoweredOnEvent{}))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// entityFilters are the filters of the config file deciding which objects of each entity type are collected
+type entityFilters struct {
+	Host                   *entityFilter `json:"host" yaml:"host"`
+	VM                     *entityFilter `json:"vm" yaml:"vm"`
+	ResourcePool           *entityFilter `json:"resourcePool" yaml:"resourcePool"`
+	ClusterComputeResource *entityFilter `json:"clusterComputeResource" yaml:"clusterComputeResource"`
+	Datastore              *entityFilter `json:"datastore" yaml:"datastore"`
+}
+
+// byType returns the configured filters indexed by managed object type
+func (f entityFilters) byType() map[string]*entityFilter {
+	filters := make(map[string]*entityFilter)
+	for moType, filter := range map[string]*entityFilter{
+		"HostSystem":             f.Host,
+		"VirtualMachine":         f.VM,
+		"ResourcePool":           f.ResourcePool,
+		"ClusterComputeResource": f.ClusterComputeResource,
+		"Datastore":              f.Datastore,
+	} {
+		if filter != nil {
+			filters[moType] = filter
+		}
+	}
+	return filters
+}
+
+// configured tells whether any entity type has filters
+func (f entityFilters) configured() bool {
+	return len(f.byType()) > 0
+}
+
+// selectNone returns a selection without any object of the entity types with filters, used when the filters cannot be evaluated
+func (f entityFilters) selectNone() *entitySelection {
+	selection := &entitySelection{
+		filtered: make(map[string]bool),
+		selected: make(map[string]bool),
+	}
+	for moType := range f.byType() {
+		selection.filtered[moType] = true
+	}
+	return selection
+}
+
+// entityFilter selects the objects of an entity type. An object is selected when it matches any of the include
+// rules, or there are none, and none of the exclude rules. Virtual machines can also be selected by power state
+// and templates left out.
+type entityFilter struct {
+	Include filterRules `json:"include" yaml:"include"`
+	Exclude filterRules `json:"exclude" yaml:"exclude"`
+	// PowerStates are the power states of the selected virtual machines: poweredOn, poweredOff or suspended
+	PowerStates []string `json:"powerStates" yaml:"powerStates"`
+	// Templates tells whether virtual machine templates are selected, they are by default
+	Templates *bool `json:"templates" yaml:"templates"`
+}
+
+// filterRules match objects by name regular expression, inventory folder or vSphere tag (category:name or name)
+type filterRules struct {
+	Names   []string `json:"names" yaml:"names"`
+	Folders []string `json:"folders" yaml:"folders"`
+	Tags    []string `json:"tags" yaml:"tags"`
+
+	names []*regexp.Regexp
+}
+
+// filterCandidate is an object entity filters are applied to
+type filterCandidate struct {
+	ref           types.ManagedObjectReference
+	name          string
+	inventoryPath string
+	powerState    string
+	template      bool
+}
+
+// compile checks the filter of the managed object type moType and compiles its name expressions
+func (f *entityFilter) compile(moType string) error {
+	if moType != "VirtualMachine" && (len(f.PowerStates) > 0 || f.Templates != nil) {
+		return fmt.Errorf("powerStates and templates only apply to virtual machines")
+	}
+	for _, powerState := range f.PowerStates {
+		switch types.VirtualMachinePowerState(powerState) {
+		case types.VirtualMachinePowerStatePoweredOn, types.VirtualMachinePowerStatePoweredOff, types.VirtualMachinePowerStateSuspended:
+		default:
+			return fmt.Errorf("unknown power state %s", powerState)
+		}
+	}
+	err := f.Include.compile()
+	if err != nil {
+		return err
+	}
+	return f.Exclude.compile()
+}
+
+func (r *filterRules) compile() error {
+	r.names = make([]*regexp.Regexp, 0, len(r.Names))
+	for _, name := range r.Names {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return fmt.Errorf("invalid name expression %s: %v", name, err)
+		}
+		r.names = append(r.names, re)
+	}
+	return nil
+}
+
+func (r *filterRules) empty() bool {
+	return len(r.Names) == 0 && len(r.Folders) == 0 && len(r.Tags) == 0
+}
+
+// matches tells whether any of the rules matches candidate. hasTag tells whether candidate has a tag.
+func (r *filterRules) matches(candidate filterCandidate, hasTag func(string) bool) bool {
+	for _, re := range r.names {
+		if re.MatchString(candidate.name) {
+			return true
+		}
+	}
+	for _, folder := range r.Folders {
+		if strings.HasPrefix(candidate.inventoryPath, strings.TrimSuffix(folder, "/")+"/") {
+			return true
+		}
+	}
+	for _, tag := range r.Tags {
+		if hasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects tells whether the filter selects candidate
+func (f *entityFilter) selects(candidate filterCandidate, hasTag func(string) bool) bool {
+	if !f.Include.empty() && !f.Include.matches(candidate, hasTag) {
+		return false
+	}
+	if f.Exclude.matches(candidate, hasTag) {
+		return false
+	}
+	if candidate.ref.Type != "VirtualMachine" {
+		return true
+	}
+	if candidate.template && f.Templates != nil && !*f.Templates {
+		return false
+	}
+	if len(f.PowerStates) == 0 {
+		return true
+	}
+	for _, powerState := range f.PowerStates {
+		if powerState == candidate.powerState {
+			return true
+		}
+	}
+	return false
+}
+
+// entitySelection holds the objects selected by the entity filters. Objects of entity types without filters are all selected.
+type entitySelection struct {
+	// filtered are the managed object types with filters
+	filtered map[string]bool
+	// selected holds the selected objects of filtered types indexed by reference value
+	selected map[string]bool
+}
+
+// selects tells whether the object ref is collected
+func (s *entitySelection) selects(ref types.ManagedObjectReference) bool {
+	if s == nil || !s.filtered[ref.Type] {
+		return true
+	}
+	return s.selected[ref.Value]
+}
+
+// selectEntities applies the entity filters of the config file to the objects of a datacenter. Tags are read
+// from labels, so tag rules require --enable_tags.
+func selectEntities(client *govmomi.Client, dc *object.Datacenter, labels *entityLabels) (*entitySelection, error) {
+	if !configuredFilters.configured() {
+		return nil, nil
+	}
+	filters := configuredFilters.byType()
+
+	finder := find.NewFinder(client.Client, true)
+	finder.SetDatacenter(dc)
+	selection := &entitySelection{
+		filtered: make(map[string]bool),
+		selected: make(map[string]bool),
+	}
+	for moType, filter := range filters {
+		if (len(filter.Include.Tags) > 0 || len(filter.Exclude.Tags) > 0) && !args.EnableTags {
+			log.Warn("%s filters use tags, which are only read with --enable_tags", moType)
+		}
+		candidates, err := listFilterCandidates(client, finder, moType)
+		if err != nil {
+			return nil, err
+		}
+
+		selection.filtered[moType] = true
+		selected := 0
+		for _, candidate := range candidates {
+			hasTag := func(tag string) bool {
+				return labels.hasTag(candidate.ref, tag)
+			}
+			if filter.selects(candidate, hasTag) {
+				selection.selected[candidate.ref.Value] = true
+				selected++
+			}
+		}
+		log.Debug("%s filters select %d of %d objects", moType, selected, len(candidates))
+	}
+	return selection, nil
+}
+
+// listFilterCandidates lists the objects of the managed object type moType with their inventory path and,
+// for virtual machines, their power state and template flag
+func listFilterCandidates(client *govmomi.Client, finder *find.Finder, moType string) ([]filterCandidate, error) {
+	ctx := context.Background()
+	var objects []object.Common
+	switch moType {
+	case "HostSystem":
+		hosts, err := finder.HostSystemList(ctx, "*")
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			objects = append(objects, host.Common)
+		}
+	case "VirtualMachine":
+		vms, err := finder.VirtualMachineList(ctx, "*")
+		if err != nil {
+			return nil, err
+		}
+		for _, vm := range vms {
+			objects = append(objects, vm.Common)
+		}
+	case "ResourcePool":
+		resourcePools, err := finder.ResourcePoolList(ctx, "*")
+		if err != nil {
+			return nil, err
+		}
+		for _, resourcePool := range resourcePools {
+			objects = append(objects, resourcePool.Common)
+		}
+	case "ClusterComputeResource":
+		clusters, err := finder.ClusterComputeResourceList(ctx, "*")
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range clusters {
+			objects = append(objects, cluster.Common)
+		}
+	case "Datastore":
+		datastores, err := finder.DatastoreList(ctx, "*")
+		if err != nil {
+			return nil, err
+		}
+		for _, datastore := range datastores {
+			objects = append(objects, datastore.Common)
+		}
+	}
+
+	candidates := make([]filterCandidate, 0, len(objects))
+	refs := make([]types.ManagedObjectReference, 0, len(objects))
+	for _, o := range objects {
+		candidates = append(candidates, filterCandidate{ref: o.Reference(), name: o.Name(), inventoryPath: o.InventoryPath})
+		refs = append(refs, o.Reference())
+	}
+	if moType != "VirtualMachine" || len(refs) == 0 {
+		return candidates, nil
+	}
+
+	var vms []mo.VirtualMachine
+	err := client.Retrieve(ctx, refs, []string{"runtime.powerState", "config.template"}, &vms)
+	if err != nil {
+		return nil, err
+	}
+	vmIndex := make(map[string]mo.VirtualMachine)
+	for _, vm := range vms {
+		vmIndex[vm.Self.Value] = vm
+	}
+	for i, candidate := range candidates {
+		vm := vmIndex[candidate.ref.Value]
+		candidates[i].powerState = string(vm.Runtime.PowerState)
+		candidates[i].template = vm.Config != nil && vm.Config.Template
+	}
+	return candidates, nil
+}
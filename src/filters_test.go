@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestEntityFilterSelects(t *testing.T) {
+	noTemplates := false
+	filter := &entityFilter{
+		Include:     filterRules{Names: []string{"^prod-"}, Folders: []string{"/DC1/vm/prod/"}, Tags: []string{"env:prod"}},
+		Exclude:     filterRules{Names: []string{"-test$"}},
+		PowerStates: []string{"poweredOn"},
+		Templates:   &noTemplates,
+	}
+	assert.NoError(t, filter.compile("VirtualMachine"))
+
+	vm := func(name string, inventoryPath string, powerState string, template bool) filterCandidate {
+		return filterCandidate{
+			ref:           types.ManagedObjectReference{Type: "VirtualMachine", Value: name},
+			name:          name,
+			inventoryPath: inventoryPath,
+			powerState:    powerState,
+			template:      template,
+		}
+	}
+	hasTag := func(tag string) bool {
+		return tag == "env:prod"
+	}
+	noTags := func(tag string) bool {
+		return false
+	}
+
+	testCases := []struct {
+		candidate filterCandidate
+		hasTag    func(string) bool
+		expected  bool
+	}{
+		{vm("prod-web", "/DC1/vm/prod-web", "poweredOn", false), noTags, true},
+		{vm("web", "/DC1/vm/prod/web", "poweredOn", false), noTags, true},
+		{vm("web", "/DC1/vm/production/web", "poweredOn", false), noTags, false},
+		{vm("web", "/DC1/vm/web", "poweredOn", false), hasTag, true},
+		{vm("prod-web-test", "/DC1/vm/prod-web-test", "poweredOn", false), noTags, false},
+		{vm("prod-web", "/DC1/vm/prod-web", "poweredOff", false), noTags, false},
+		{vm("prod-template", "/DC1/vm/prod-template", "poweredOn", true), noTags, false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, filter.selects(tc.candidate, tc.hasTag), "%+v", tc.candidate)
+	}
+}
+
+func TestEntityFilterCompile(t *testing.T) {
+	assert.Error(t, (&entityFilter{PowerStates: []string{"poweredOn"}}).compile("HostSystem"))
+	assert.Error(t, (&entityFilter{PowerStates: []string{"running"}}).compile("VirtualMachine"))
+	assert.Error(t, (&entityFilter{Exclude: filterRules{Names: []string{"("}}}).compile("Datastore"))
+	assert.NoError(t, (&entityFilter{Exclude: filterRules{Names: []string{"^lab-"}}}).compile("Datastore"))
+}
+
+func TestEntitySelection(t *testing.T) {
+	selection := &entitySelection{
+		filtered: map[string]bool{"VirtualMachine": true},
+		selected: map[string]bool{"vm-1": true},
+	}
+	assert.True(t, selection.selects(types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}))
+	assert.False(t, selection.selects(types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-2"}))
+	assert.True(t, selection.selects(types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"}))
+
+	filters := entityFilters{VM: &entityFilter{}}
+	assert.True(t, filters.configured())
+	assert.False(t, entityFilters{}.configured())
+	none := filters.selectNone()
+	assert.False(t, none.selects(types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}))
+	assert.True(t, none.selects(types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"}))
+
+	var noSelection *entitySelection
+	assert.True(t, noSelection.selects(types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-2"}))
+}
+
+func TestHasTag(t *testing.T) {
+	labels := &entityLabels{labels: make(map[string]map[string]string)}
+	ref := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	labels.set(ref, "tag.env", "lab,prod")
+	labels.set(ref, "label.owner", "prod")
+
+	assert.True(t, labels.hasTag(ref, "env:prod"))
+	assert.True(t, labels.hasTag(ref, "lab"))
+	assert.False(t, labels.hasTag(ref, "owner:prod"))
+	assert.False(t, labels.hasTag(ref, "team:prod"))
+}
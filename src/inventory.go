@@ -2,24 +2,26 @@ package main
 
 import (
 	"context"
+	"sort"
+	"strings"
 
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
-func populateInventory(client *govmomi.Client, dc *object.Datacenter) (map[string]map[string]interface{}, error) {
+func populateHostInventory(entities *entityResolver, client *govmomi.Client, dc *object.Datacenter, selection *entitySelection) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	hsSummary := make(map[string]map[string]interface{})
 	// Create a view of HostSystem objects
 	manager := view.NewManager(client.Client)
 
 	view, err := manager.CreateContainerView(ctx, dc.Reference(), []string{"HostSystem"}, true)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	defer func() {
@@ -29,26 +31,186 @@ func populateInventory(client *govmomi.Client, dc *object.Datacenter) (map[strin
 	}()
 
 	var hss []mo.HostSystem
-	err = view.Retrieve(ctx, []string{"HostSystem"}, []string{"summary"}, &hss)
+	err = view.Retrieve(ctx, []string{"HostSystem"}, []string{"summary", "hardware.biosInfo"}, &hss)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	for _, hs := range hss {
+		if !selection.selects(hs.Self) {
+			continue
+		}
 		name := hs.Summary.Config.Name
-		hsMetrics, ok := hsSummary[name]
-		if !ok {
-			hsMetrics = make(map[string]interface{})
-			hsSummary[name] = hsMetrics
+		key := "host/" + name
+		items := make(map[string]interface{})
+
+		if product := hs.Summary.Config.Product; product != nil {
+			items["productName"] = product.Name
+			items["productVersion"] = product.Version
+			items["productBuild"] = product.Build
+		}
+		if hardware := hs.Summary.Hardware; hardware != nil {
+			items["vendor"] = hardware.Vendor
+			items["model"] = hardware.Model
+			items["cpuModel"] = hardware.CpuModel
+			items["cpuMhz"] = hardware.CpuMhz
+			items["cpuPackages"] = hardware.NumCpuPkgs
+			items["cpuCores"] = hardware.NumCpuCores
+			items["cpuThreads"] = hardware.NumCpuThreads
+			items["memorySize"] = hardware.MemorySize
+			items["nicCount"] = hardware.NumNics
+			items["hbaCount"] = hardware.NumHBAs
+		}
+		if hs.Hardware != nil && hs.Hardware.BiosInfo != nil {
+			items["biosVersion"] = hs.Hardware.BiosInfo.BiosVersion
+		}
+
+		entity := entities.entity(hs.Self, name)
+		for field, value := range items {
+			err = entity.SetInventoryItem(key, field, value)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func populateVMInventory(entities *entityResolver, client *govmomi.Client, dc *object.Datacenter, selection *entitySelection) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Create a view of VirtualMachine objects
+	manager := view.NewManager(client.Client)
+
+	view, err := manager.CreateContainerView(ctx, dc.Reference(), []string{"VirtualMachine"}, true)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := view.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	// only the config properties reported are retrieved, the whole config also holds extraConfig and every device backing
+	vmProperties := []string{
+		"name",
+		"config.guestId",
+		"config.version",
+		"config.annotation",
+		"config.template",
+		"config.cpuHotAddEnabled",
+		"config.memoryHotAddEnabled",
+		"config.tools.toolsVersion",
+		"config.hardware.device",
+		"guest.toolsRunningStatus",
+		"guest.toolsVersion",
+		"network",
+	}
+	var vms []mo.VirtualMachine
+	err = view.Retrieve(ctx, []string{"VirtualMachine"}, vmProperties, &vms)
+	if err != nil {
+		return err
+	}
+
+	networkNames, err := retrieveNetworkNames(ctx, client, vms)
+	if err != nil {
+		log.Error("unable to retrieve virtual machine network names: %v", err)
+	}
+
+	for _, vm := range vms {
+		if !selection.selects(vm.Self) {
+			continue
+		}
+		key := "vm/" + vm.Name
+		entity := entities.entity(vm.Self, vm.Name)
+		items := make(map[string]interface{})
+
+		if config := vm.Config; config != nil {
+			items["guestId"] = config.GuestId
+			items["hardwareVersion"] = config.Version
+			items["annotation"] = config.Annotation
+			items["template"] = config.Template
+			if config.CpuHotAddEnabled != nil {
+				items["cpuHotAddEnabled"] = *config.CpuHotAddEnabled
+			}
+			if config.MemoryHotAddEnabled != nil {
+				items["memoryHotAddEnabled"] = *config.MemoryHotAddEnabled
+			}
+			if config.Tools != nil {
+				items["toolsVersion"] = config.Tools.ToolsVersion
+			}
+
+			diskCount := 0
+			var diskCapacity int64
+			macAddresses := make([]string, 0)
+			for _, device := range config.Hardware.Device {
+				switch d := device.(type) {
+				case *types.VirtualDisk:
+					diskCount++
+					if d.CapacityInBytes > 0 {
+						diskCapacity += d.CapacityInBytes
+					} else {
+						diskCapacity += d.CapacityInKB * 1024
+					}
+				case types.BaseVirtualEthernetCard:
+					macAddresses = append(macAddresses, d.GetVirtualEthernetCard().MacAddress)
+				}
+			}
+			sort.Strings(macAddresses)
+			items["diskCount"] = diskCount
+			items["diskCapacity"] = diskCapacity
+			items["macAddresses"] = strings.Join(macAddresses, ",")
+		}
+		if guest := vm.Guest; guest != nil {
+			items["toolsRunningStatus"] = guest.ToolsRunningStatus
+			items["toolsVersionName"] = guest.ToolsVersion
 		}
-		totalCPU := int64(hs.Summary.Hardware.CpuMhz) * int64(hs.Summary.Hardware.NumCpuCores)
-		freeCPU := int64(totalCPU) - int64(hs.Summary.QuickStats.OverallCpuUsage)
-		freeMemory := int64(hs.Summary.Hardware.MemorySize) - (int64(hs.Summary.QuickStats.OverallMemoryUsage) * 1024 * 1024)
 
-		hsMetrics["hs.overallCPU"] = hs.Summary.QuickStats.OverallCpuUsage
-		hsMetrics["hs.totalCPU"] = totalCPU
-		hsMetrics["hs.freeCPU"] = freeCPU
-		hsMetrics["freeMemory"] = freeMemory
+		networks := make([]string, 0)
+		for _, ref := range vm.Network {
+			if name, ok := networkNames[ref.Value]; ok {
+				networks = append(networks, name)
+			}
+		}
+		sort.Strings(networks)
+		items["networks"] = strings.Join(networks, ",")
+
+		for field, value := range items {
+			err = entity.SetInventoryItem(key, field, value)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// retrieveNetworkNames returns the names of the networks attached to vms indexed by their reference value
+func retrieveNetworkNames(ctx context.Context, client *govmomi.Client, vms []mo.VirtualMachine) (map[string]string, error) {
+	names := make(map[string]string)
+	refs := make([]types.ManagedObjectReference, 0)
+	seen := make(map[string]bool)
+	for _, vm := range vms {
+		for _, ref := range vm.Network {
+			if !seen[ref.Value] {
+				seen[ref.Value] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	if len(refs) == 0 {
+		return names, nil
+	}
+
+	var networks []mo.Network
+	err := client.Retrieve(ctx, refs, []string{"name"}, &networks)
+	if err != nil {
+		return names, err
+	}
+	for _, network := range networks {
+		names[network.Self.Value] = network.Name
 	}
-	return hsSummary, nil
+	return names, nil
 }
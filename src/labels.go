@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// labelEntityTypes are the managed entity types whose samples are decorated with labels
+var labelEntityTypes = []string{"HostSystem", "VirtualMachine", "Datastore", "ResourcePool", "ClusterComputeResource"}
+
+// entityLabels holds the custom attributes (label.<key>) and tags (tag.<category>) of the
+// entities of a datacenter, indexed by managed object reference value
+type entityLabels struct {
+	labels map[string]map[string]string
+}
+
+func collectEntityLabels(client *govmomi.Client, dc *object.Datacenter, tags *tagClient) (*entityLabels, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := &entityLabels{labels: make(map[string]map[string]string)}
+	// Create a view of the entities that are reported as samples
+	manager := view.NewManager(client.Client)
+
+	view, err := manager.CreateContainerView(ctx, dc.Reference(), labelEntityTypes, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := view.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	var entities []mo.ManagedEntity
+	err = view.Retrieve(ctx, labelEntityTypes, []string{"customValue", "availableField"}, &entities)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]types.ManagedObjectReference, 0, len(entities))
+	for _, entity := range entities {
+		refs = append(refs, entity.Self)
+		fieldNames := make(map[int32]string)
+		for _, field := range entity.AvailableField {
+			fieldNames[field.Key] = field.Name
+		}
+		for _, value := range entity.CustomValue {
+			stringValue, ok := value.(*types.CustomFieldStringValue)
+			if !ok || stringValue.Value == "" {
+				continue
+			}
+			if name, ok := fieldNames[stringValue.Key]; ok {
+				l.set(entity.Self, "label."+name, stringValue.Value)
+			}
+		}
+	}
+
+	if tags != nil && len(refs) > 0 {
+		attached, err := tags.attachedTags(refs)
+		if err != nil {
+			log.Error("unable to retrieve vSphere tags: %v", err)
+		}
+		for _, ref := range refs {
+			for category, names := range attached[ref.Value] {
+				sort.Strings(names)
+				l.set(ref, "tag."+category, strings.Join(names, ","))
+			}
+		}
+	}
+	return l, nil
+}
+
+func (l *entityLabels) set(ref types.ManagedObjectReference, key string, value string) {
+	labels, ok := l.labels[ref.Value]
+	if !ok {
+		labels = make(map[string]string)
+		l.labels[ref.Value] = labels
+	}
+	labels[key] = value
+}
+
+// decorate adds the labels of the entity ref to a metric set
+func (l *entityLabels) decorate(ms *metric.Set, ref types.ManagedObjectReference) {
+	if l == nil {
+		return
+	}
+	for key, value := range l.labels[ref.Value] {
+		err := ms.SetMetric(key, value, metric.ATTRIBUTE)
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}
+}
+
+// hasTag tells whether the entity ref has a vSphere tag, given as category:name or name
+func (l *entityLabels) hasTag(ref types.ManagedObjectReference, tag string) bool {
+	if l == nil {
+		return false
+	}
+	category, name := "", tag
+	if separator := strings.Index(tag, ":"); separator >= 0 {
+		category, name = tag[:separator], tag[separator+1:]
+	}
+	for key, value := range l.labels[ref.Value] {
+		if !strings.HasPrefix(key, "tag.") || (category != "" && key != "tag."+category) {
+			continue
+		}
+		for _, tagName := range strings.Split(value, ",") {
+			if tagName == name {
+				return true
+			}
+		}
+	}
+	return false
+}
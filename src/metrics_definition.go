@@ -375,3 +375,61 @@ var defaultVMCounters = []string{
 	"net.usage.maximum",
 	"net.usage.none",
 }
+
+var defaultResourcePoolCounters = []string{
+	"cpu.usagemhz.average",
+	"cpu.usagemhz.minimum",
+	"cpu.usagemhz.maximum",
+	"cpu.usagemhz.none",
+	"mem.capacity.contention.average",
+	"mem.capacity.entitlement.average",
+	"mem.capacity.provisioned.average",
+	"mem.capacity.usable.average",
+	"mem.capacity.usage.average",
+	"mem.consumed.average",
+	"mem.consumed.minimum",
+	"mem.consumed.maximum",
+	"mem.consumed.none",
+	"mem.overhead.average",
+	"mem.overhead.minimum",
+	"mem.overhead.maximum",
+	"mem.overhead.none",
+	"mem.vmmemctl.average",
+	"mem.vmmemctl.minimum",
+	"mem.vmmemctl.maximum",
+	"mem.vmmemctl.none",
+	"disk.throughput.contention.average",
+	"disk.throughput.usage.average",
+	"net.throughput.contention.summation",
+	"net.throughput.usage.average",
+}
+
+var defaultClusterCounters = []string{
+	"clusterServices.cpufairness.latest",
+	"clusterServices.effectivecpu.average",
+	"clusterServices.effectivemem.average",
+	"clusterServices.failover.latest",
+	"clusterServices.memfairness.latest",
+	"cpu.totalmhz.average",
+	"cpu.usage.average",
+	"cpu.usagemhz.average",
+	"mem.active.average",
+	"mem.consumed.average",
+	"mem.granted.average",
+	"mem.overhead.average",
+	"mem.totalmb.average",
+	"mem.usage.average",
+	"mem.vmmemctl.average",
+	"vmop.numPoweroff.latest",
+	"vmop.numPoweron.latest",
+	"vmop.numSVMotion.latest",
+	"vmop.numVMotion.latest",
+}
+
+var defaultDatastoreCounters = []string{
+	"disk.used.latest",
+	"disk.capacity.latest",
+	"disk.provisioned.latest",
+	"disk.numberReadAveraged.average",
+	"disk.numberWriteAveraged.average",
+}
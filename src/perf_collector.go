@@ -3,229 +3,542 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/vmware/govmomi/find"
 
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
-	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/vim25/methods"
-	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
 type perfCollector struct {
-	client *govmomi.Client
-	entity *integration.Entity
-	finder *find.Finder
+	client   *govmomi.Client
+	entities *entityResolver
+	finder   *find.Finder
 
-	metricFilter string
+	metricToCounterMap  map[int32]counterMetadata
+	nameToMetricMap     map[string]int32
+	historicalIntervals []types.PerfInterval
+	// availableCounterCache holds the counters supported by each entity type
+	availableCounterCache map[string]map[int32]bool
+	summaryMetrics        map[string]map[string]interface{}
+	labels                *entityLabels
+	// selection holds the objects selected by the entity filters
+	selection     *entitySelection
+	hostMetricIds []types.PerfMetricId
 
-	metricToNameMap map[int32]string
-	nameToMetricMap map[string]int32
-	summaryMetrics  map[string]map[string]interface{}
-	hostMetricIds   []types.PerfMetricId
+	// errors counts the failed queries of each entity type by cause
+	errors perfErrors
+
+	// queries bounds the number of performance queries running in parallel across entity types
+	queries *workerPool
+	// mutex guards the caches, error counts and store shared by concurrent queries,
+	// and the metric sets written while processing their results
+	mutex sync.Mutex
+
+	// store keeps the timestamp of the last sample reported for each entity when --aggregate_samples is set
+	store persist.Storer
 }
 
-func (c *perfCollector) initCounterMetadata() (err error) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	var perfManager mo.PerformanceManager
-	err = c.client.RetrieveOne(ctx, *c.client.ServiceContent.PerfManager, nil, &perfManager)
+// initCounterMetadata loads the counter catalog. A catalog read from the cache is refreshed from vCenter when
+// counters of the given lists cannot be found in it, as they may have been added since it was cached. Counters
+// vCenter does not provide at all cause at most one refresh every --counter_cache_ttl minutes.
+func (c *perfCollector) initCounterMetadata(counterLists ...[]string) error {
+	catalog, err := loadCounterCatalog(c.client, false)
 	if err != nil {
-		log.Error("Could not retrieve performance manager")
 		return err
 	}
-	//interval := perfManager.HistoricalInterval
-	//log.Debug(interval[0].SamplingPeriod)
-	perfCounters := perfManager.PerfCounter
-
-	c.metricToNameMap = make(map[int32]string)
-	c.nameToMetricMap = make(map[string]int32)
+	c.setCatalog(catalog)
 
-	printCounters := args.LogAvailableCounters
-	if printCounters {
-		fmt.Printf("LogAvailableCounters FLAG ON, printing all %d available counters", len(perfCounters))
+	if catalog.retrieved {
+		return nil
 	}
-	for _, perfCounter := range perfCounters {
-		groupInfo := perfCounter.GroupInfo.GetElementDescription()
-		nameInfo := perfCounter.NameInfo.GetElementDescription()
-		fullCounterName := groupInfo.Key + "." + nameInfo.Key + "." + fmt.Sprint(perfCounter.RollupType)
-		c.nameToMetricMap[fullCounterName] = perfCounter.Key
-		c.metricToNameMap[perfCounter.Key] = fullCounterName
-		if printCounters {
-			fmt.Printf("\t %s [%d]\n", fullCounterName, perfCounter.Level)
+	for _, counterList := range counterLists {
+		if _, missing := resolveCounters(c.nameToMetricMap, counterList); len(missing) > 0 {
+			catalog, err = refreshCounterCatalogAfterMiss(c.client, missing)
+			if err != nil {
+				return err
+			}
+			c.setCatalog(catalog)
+			break
 		}
 	}
 	return nil
 }
 
+func (c *perfCollector) setCatalog(catalog *counterCatalog) {
+	c.historicalIntervals = catalog.HistoricalIntervals
+	c.metricToCounterMap = make(map[int32]counterMetadata)
+	c.nameToMetricMap = make(map[string]int32)
+	for _, counter := range catalog.Counters {
+		c.nameToMetricMap[counter.Name] = counter.Key
+		c.metricToCounterMap[counter.Key] = counter
+	}
+}
+
+// instanceSamplePrefixes are the event type prefixes of per instance samples, e.g. ESXHostNicSample
+var instanceSamplePrefixes = map[string]string{
+	"Host System":              "ESXHost",
+	"Virtual Machine":          "ESXVirtualMachine",
+	"Resource Pool":            "ESXResourcePool",
+	"Cluster Compute Resource": "ESXCluster",
+	"Datastore":                "ESXDatastore",
+}
+
+// instanceSampleGroups name the per instance sample each counter group is reported on
+var instanceSampleGroups = map[string]string{
+	"cpu":            "Cpu",
+	"mem":            "Memory",
+	"disk":           "Disk",
+	"virtualDisk":    "VirtualDisk",
+	"datastore":      "Datastore",
+	"storageAdapter": "StorageAdapter",
+	"storagePath":    "StoragePath",
+	"net":            "Nic",
+	"power":          "Power",
+	"sys":            "System",
+}
+
+// maxAvailableCounterQueries is the number of entities of a type asked for their available counters
+const maxAvailableCounterQueries = 5
+
+// historicalLookBack is the minimum time the latest historical sample of an entity type is looked for. vCenter
+// only computes the space counters of datastores (disk.used, disk.capacity, disk.provisioned) every 30 minutes.
+var historicalLookBack = map[string]time.Duration{
+	"Datastore": time.Hour,
+}
+
+// perfInterval is the sampling interval the performance metrics of an entity type are queried with
+type perfInterval struct {
+	id int32
+	// realTime is set when the entities provide real-time (instance) data,
+	// otherwise the latest historical sample is queried from startTime
+	realTime  bool
+	startTime *time.Time
+}
+
+// perfEntity is a managed object performance metrics are queried for
+type perfEntity struct {
+	name string
+	ref  types.ManagedObjectReference
+}
+
 func (c *perfCollector) collect(entityType string, nrEventType string, counterList []string) error {
-	missingCounters := make([]string, 0)
-	metricIds := make([]types.PerfMetricId, 0)
-	for _, fullCounterName := range counterList {
-		counterID, ok := c.nameToMetricMap[fullCounterName]
-		if ok {
-			metricID := types.PerfMetricId{CounterId: counterID, Instance: "*"}
-			metricIds = append(metricIds, metricID)
-		} else {
-			missingCounters = append(missingCounters, fullCounterName)
+	counterIDs, missingCounters := resolveCounters(c.nameToMetricMap, counterList)
+	if len(missingCounters) > 0 {
+		log.Warn("unable to find `%s` counters: %v", entityType, missingCounters)
+	}
+	// a query without counters would return every counter available
+	if len(counterIDs) == 0 {
+		log.Warn("no `%s` counters are configured, performance metrics are not queried", entityType)
+		return nil
+	}
+
+	entities, err := c.discover(entityType)
+	if err != nil {
+		return err
+	}
+	if args.Verbose {
+		discovered := make([]string, 0)
+		for _, e := range entities {
+			discovered = append(discovered, e.name)
+		}
+		log.Info("discovered %s: %v ", entityType, discovered)
+	}
+
+	if len(entities) == 0 {
+		return nil
+	}
+	interval, err := c.queryInterval(entityType, entities[0])
+	if err != nil {
+		return err
+	}
+
+	available := c.availableCounters(entityType, entities, interval)
+	metricIds := make([]types.PerfMetricId, 0, len(counterIDs))
+	unsupportedCounters := make([]string, 0)
+	for _, counterID := range counterIDs {
+		if available != nil && !available[counterID] {
+			unsupportedCounters = append(unsupportedCounters, c.metricToCounterMap[counterID].Name)
+			continue
+		}
+		metricIds = append(metricIds, types.PerfMetricId{CounterId: counterID, Instance: "*"})
+	}
+	if len(unsupportedCounters) > 0 {
+		log.Warn("%d `%s` counters are not available and will not be requested: %v", len(unsupportedCounters), entityType, unsupportedCounters)
+	}
+	if len(metricIds) == 0 {
+		log.Warn("none of the `%s` counters are available", entityType)
+		return nil
+	}
+
+	batchSize := args.PerfBatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	var wg sync.WaitGroup
+	for start := 0; start < len(entities); start += batchSize {
+		end := start + batchSize
+		if end > len(entities) {
+			end = len(entities)
 		}
+		batch := entities[start:end]
+		c.queries.run(&wg, func() {
+			c.collectBatch(entityType, nrEventType, batch, metricIds, interval)
+		})
 	}
-	log.Warn("unable to find `%s` counters: %v", entityType, missingCounters)
+	wg.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if summary := c.errors.summary(entityType); summary != "" {
+		return fmt.Errorf("performance queries failed: %s", summary)
+	}
+	return nil
+}
+
+// discover lists the managed objects of entityType in the datacenter selected by the entity filters
+func (c *perfCollector) discover(entityType string) ([]perfEntity, error) {
+	ctx := context.Background()
+	entities := make([]perfEntity, 0)
 
 	switch entityType {
 	case "Host System":
-		hosts, err := c.finder.HostSystemList(context.Background(), "*")
+		hosts, err := c.finder.HostSystemList(ctx, "*")
 		if err != nil {
-			return err
-		}
-		if args.Verbose {
-			discoveredHosts := make([]string, 0)
-			for _, host := range hosts {
-				discoveredHosts = append(discoveredHosts, host.Name())
-			}
-			log.Info("discovered host systems: %v ", discoveredHosts)
+			return nil, err
 		}
 		for _, host := range hosts {
-			err = c.collectMetrics(entityType, nrEventType, host.Name(), host.Reference(), metricIds)
-			if err != nil {
-				log.Error(err.Error())
-			}
+			entities = append(entities, perfEntity{name: host.Name(), ref: host.Reference()})
 		}
 	case "Virtual Machine":
-		vms, err := c.finder.VirtualMachineList(context.Background(), "*")
+		vms, err := c.finder.VirtualMachineList(ctx, "*")
 		if err != nil {
-			return err
-		}
-		if args.Verbose {
-			discoveredVms := make([]string, 0)
-			for _, vm := range vms {
-				discoveredVms = append(discoveredVms, vm.Name())
-			}
-			log.Info("discovered virtual machines: %v ", discoveredVms)
+			return nil, err
 		}
 		for _, vm := range vms {
-			err = c.collectMetrics(entityType, nrEventType, vm.Name(), vm.Reference(), metricIds)
-			if err != nil {
-				log.Error(err.Error())
-			}
+			entities = append(entities, perfEntity{name: vm.Name(), ref: vm.Reference()})
 		}
 	case "Resource Pool":
-		resourcePools, err := c.finder.ResourcePoolList(context.Background(), "*")
+		resourcePools, err := c.finder.ResourcePoolList(ctx, "*")
 		if err != nil {
-			return err
-		}
-		if args.Verbose {
-			discoveredResourcePools := make([]string, 0)
-			for _, resourcePool := range resourcePools {
-				discoveredResourcePools = append(discoveredResourcePools, resourcePool.Name())
-			}
-			log.Info("discovered resource pools: %v ", discoveredResourcePools)
+			return nil, err
 		}
 		for _, resourcePool := range resourcePools {
-			err = c.collectMetrics(entityType, nrEventType, resourcePool.Name(), resourcePool.Reference(), metricIds)
-			if err != nil {
-				log.Error(err.Error())
-			}
+			entities = append(entities, perfEntity{name: resourcePool.Name(), ref: resourcePool.Reference()})
 		}
-	case "Datastore":
-		datastores, err := c.finder.DatastoreList(context.Background(), "*")
+	case "Cluster Compute Resource":
+		clusters, err := c.finder.ClusterComputeResourceList(ctx, "*")
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if args.Verbose {
-			discoveredDatastores := make([]string, 0)
-			for _, datastore := range datastores {
-				discoveredDatastores = append(discoveredDatastores, datastore.Name())
-			}
-			log.Info("discovered datastores: %v ", discoveredDatastores)
+		for _, cluster := range clusters {
+			entities = append(entities, perfEntity{name: cluster.Name(), ref: cluster.Reference()})
+		}
+	case "Datastore":
+		datastores, err := c.finder.DatastoreList(ctx, "*")
+		if err != nil {
+			return nil, err
 		}
 		for _, datastore := range datastores {
-			err = c.collectMetrics(entityType, nrEventType, datastore.Name(), datastore.Reference(), metricIds)
-			if err != nil {
-				log.Error(err.Error())
+			entities = append(entities, perfEntity{name: datastore.Name(), ref: datastore.Reference()})
+		}
+	}
+	if c.selection == nil {
+		return entities, nil
+	}
+	selected := make([]perfEntity, 0, len(entities))
+	for _, e := range entities {
+		if c.selection.selects(e.ref) {
+			selected = append(selected, e)
+		}
+	}
+	return selected, nil
+}
+
+// queryInterval chooses the interval the performance metrics of entityType are queried with. Hosts and
+// virtual machines provide real-time data sampled every 20 seconds. Datastores, clusters and resource pools
+// only provide historical rollups, so their latest complete historical sample is queried instead.
+func (c *perfCollector) queryInterval(entityType string, e perfEntity) (perfInterval, error) {
+	ctx := context.Background()
+	summary, err := methods.QueryPerfProviderSummary(ctx, c.client, &types.QueryPerfProviderSummary{
+		This:   *c.client.ServiceContent.PerfManager,
+		Entity: e.ref,
+	})
+	if err != nil {
+		return perfInterval{}, fmt.Errorf("unable to query performance provider summary for %s[ %s ]: %v", entityType, e.name, err)
+	}
+
+	var interval perfInterval
+	if summary.Returnval.CurrentSupported {
+		interval = perfInterval{id: summary.Returnval.RefreshRate, realTime: true}
+	} else {
+		var samplingPeriod int32
+		for _, historicalInterval := range c.historicalIntervals {
+			if historicalInterval.Enabled && (samplingPeriod == 0 || historicalInterval.SamplingPeriod < samplingPeriod) {
+				samplingPeriod = historicalInterval.SamplingPeriod
 			}
 		}
+		if samplingPeriod == 0 {
+			return perfInterval{}, fmt.Errorf("%s does not provide real-time statistics and no historical interval is enabled", entityType)
+		}
+
+		now, err := methods.GetCurrentTime(ctx, c.client)
+		if err != nil {
+			return perfInterval{}, err
+		}
+		// historical samples are rolled up with some delay, look back far enough to find a complete one
+		lookBack := 3 * time.Duration(samplingPeriod) * time.Second
+		if lookBack < historicalLookBack[entityType] {
+			lookBack = historicalLookBack[entityType]
+		}
+		startTime := now.Add(-lookBack)
+		interval = perfInterval{id: samplingPeriod, startTime: &startTime}
 	}
-	return nil
+	log.Debug("querying %s with interval %d (real-time: %v)", entityType, interval.id, interval.realTime)
+	return interval, nil
 }
 
-func (c *perfCollector) collectMetrics(entityType, nrEventType, name string, moref types.ManagedObjectReference, metricIds []types.PerfMetricId) error {
+// availableCounters returns the counters entities of entityType support: the union of the counters reported by
+// QueryAvailablePerfMetric for the first entities of the type, as entities without a NIC, a second disk or some
+// adapter type do not report the counters of the devices they lack. Powered off virtual machines report none.
+// It returns nil when the supported counters cannot be determined, in which case every configured counter is requested.
+func (c *perfCollector) availableCounters(entityType string, entities []perfEntity, interval perfInterval) map[int32]bool {
+	c.mutex.Lock()
+	available, ok := c.availableCounterCache[entityType]
+	c.mutex.Unlock()
+	if ok {
+		return available
+	}
+
 	ctx := context.Background()
-	log.Info(fmt.Sprintf("querying %s for %s", entityType, name))
+	for i, e := range entities {
+		if i == maxAvailableCounterQueries {
+			break
+		}
+		res, err := methods.QueryAvailablePerfMetric(ctx, c.client, &types.QueryAvailablePerfMetric{
+			This:       *c.client.ServiceContent.PerfManager,
+			Entity:     e.ref,
+			IntervalId: interval.id,
+		})
+		if err != nil {
+			// not a QueryPerf failure, every configured counter is requested when none of the entities answers
+			log.Warn("unable to query available counters for %s[ %s ] (%s): %v", entityType, e.name, classifyError(err), err)
+			continue
+		}
+		if len(res.Returnval) == 0 {
+			continue
+		}
+		if available == nil {
+			available = make(map[int32]bool)
+		}
+		for _, metricID := range res.Returnval {
+			available[metricID.CounterId] = true
+		}
+	}
 
-	ms := c.entity.NewMetricSet(nrEventType)
-	err := ms.SetMetric("name", name, metric.ATTRIBUTE)
-	if err != nil {
-		log.Error(err.Error())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.availableCounterCache == nil {
+		c.availableCounterCache = make(map[string]map[int32]bool)
 	}
+	c.availableCounterCache[entityType] = available
+	return available
+}
+
+// newMetricSet creates the sample of an entity with its name, labels and previously collected summary metrics
+func (c *perfCollector) newMetricSet(nrEventType string, e perfEntity) *metric.Set {
+	ms := c.entities.newMetricSet(nrEventType, e.ref, e.name, metric.Attr("name", e.name))
+	c.labels.decorate(ms, e.ref)
+
 	//add in summary metrics previously collected
-	summaryMetrics, ok := c.summaryMetrics[name]
+	summaryMetrics, ok := c.summaryMetrics[e.ref.Value]
 	if ok {
-		fmt.Println("Adding summary metrics for " + name)
-		for k, v := range summaryMetrics {
-			switch tv := v.(type) {
-			case string:
-				err = ms.SetMetric(k, tv, metric.ATTRIBUTE)
-				if err != nil {
-					log.Error(err.Error())
-				}
-			case bool:
-				var val int
-				if tv {
-					val = 1
-				}
-				err = ms.SetMetric(k, val, metric.GAUGE)
-				if err != nil {
-					log.Error(err.Error())
-				}
-			case int, int64, int32, float32, float64:
-				err = ms.SetMetric(k, tv, metric.GAUGE)
-				if err != nil {
-					log.Error(err.Error())
-				}
-			default:
-				log.Error("unknown metric value datatype %T", v)
+		log.Debug("adding summary metrics for %s", e.name)
+		setSummaryMetrics(ms, summaryMetrics)
+	}
+	return ms
+}
+
+// cpuCount returns the number of vCPUs of a virtual machine or CPU threads of a host, or 0 when it is unknown
+func (c *perfCollector) cpuCount(ref types.ManagedObjectReference) int32 {
+	summaryMetrics := c.summaryMetrics[ref.Value]
+	for _, key := range []string{"numCpu", "numCpuThreads"} {
+		if count, ok := summaryMetrics[key].(int32); ok {
+			return count
+		}
+	}
+	return 0
+}
+
+// collectBatch queries the performance metrics of a batch of entities. An entity removed since it was discovered
+// fails the whole QueryPerf call with ManagedObjectNotFound, so the entities of such a batch are queried again
+// one at a time and only the removed ones are left out.
+func (c *perfCollector) collectBatch(entityType, nrEventType string, batch []perfEntity, metricIds []types.PerfMetricId, interval perfInterval) {
+	err := c.collectMetrics(entityType, nrEventType, batch, metricIds, interval)
+	if err == nil {
+		return
+	}
+	if classifyError(err) == errorClassNotFound {
+		if len(batch) > 1 {
+			log.Debug("a `%s` batch of %d entities references a removed entity, querying them one at a time", entityType, len(batch))
+			for _, e := range batch {
+				c.collectBatch(entityType, nrEventType, []perfEntity{e}, metricIds, interval)
 			}
+			return
 		}
+		log.Warn("%s[ %s ] was removed since it was discovered: %v", entityType, batch[0].name, err)
+		return
 	}
+	c.mutex.Lock()
+	c.errors.add(entityType, err)
+	c.mutex.Unlock()
+	log.Error("unable to query `%s` metrics for %d entities: %v", entityType, len(batch), err)
+}
+
+// collectMetrics queries the performance metrics of a batch of entities with a single QueryPerf call. Samples
+// are only created for the entities data is returned for.
+func (c *perfCollector) collectMetrics(entityType, nrEventType string, entities []perfEntity, metricIds []types.PerfMetricId, interval perfInterval) error {
+	ctx := context.Background()
+	log.Info(fmt.Sprintf("querying %s for %d entities", entityType, len(entities)))
+
+	batch := make(map[string]perfEntity)
+	querySpecs := make([]types.PerfQuerySpec, 0, len(entities))
+	for _, e := range entities {
+		batch[e.ref.Value] = e
 
-	//Note about IntervalId: ESXi Servers sample performance data every 20 seconds. 20-second interval data is called instance data or real-time data
-	//TODO It may be required to also specify begin and end times.
-	querySpec := types.PerfQuerySpec{
-		Entity:     moref,
-		MaxSample:  1,
-		MetricId:   metricIds,
-		IntervalId: 20,
+		querySpec := types.PerfQuerySpec{
+			Entity:     e.ref,
+			MetricId:   metricIds,
+			IntervalId: interval.id,
+		}
+		if !interval.realTime {
+			querySpec.StartTime = interval.startTime
+		} else if lastSample, ok := c.lastSample(e); ok {
+			querySpec.StartTime = &lastSample
+		} else {
+			querySpec.MaxSample = 1
+		}
+		querySpecs = append(querySpecs, querySpec)
 	}
 
 	query := types.QueryPerf{
 		This:      *c.client.ServiceContent.PerfManager,
-		QuerySpec: []types.PerfQuerySpec{querySpec},
+		QuerySpec: querySpecs,
 	}
 
-	retrievedStats, _ := methods.QueryPerf(ctx, c.client, &query)
-	if retrievedStats == nil || len(retrievedStats.Returnval) == 0 {
-		log.Warn("no results returned from query execution for %s[ %s ]", entityType, name)
+	retrievedStats, err := methods.QueryPerf(ctx, c.client, &query)
+	if err != nil {
+		return err
+	}
+	if len(retrievedStats.Returnval) == 0 {
+		log.Warn("no results returned from query execution for %d %s entities, check the statistics level of the counters", len(entities), entityType)
 		return nil
 	}
-	singleEntityPerfStats := retrievedStats.Returnval[0]
 
-	metricsValues := singleEntityPerfStats.(*types.PerfEntityMetric).Value
-	for _, metricValue := range metricsValues {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	returned := make(map[string]bool)
+	for _, entityPerfStats := range retrievedStats.Returnval {
+		entityMetric, ok := entityPerfStats.(*types.PerfEntityMetric)
+		if !ok {
+			log.Warn("unknown BasePerfEntityMetric type %T", entityPerfStats)
+			continue
+		}
+		e, ok := batch[entityMetric.Entity.Value]
+		if !ok || len(entityMetric.Value) == 0 {
+			continue
+		}
+		returned[e.ref.Value] = true
+		ms := c.newMetricSet(nrEventType, e)
+		c.processEntityMetric(entityType, e, ms, entityMetric, interval.realTime && c.store != nil)
+		if c.store != nil && len(entityMetric.SampleInfo) > 0 {
+			c.store.Set(lastSampleKey(entityMetric.Entity), entityMetric.SampleInfo[len(entityMetric.SampleInfo)-1].Timestamp)
+		}
+	}
+	for _, e := range entities {
+		if !returned[e.ref.Value] {
+			log.Warn("no results returned from query execution for %s[ %s ]", entityType, e.name)
+		}
+	}
+	return nil
+}
+
+// lastSample returns the timestamp of the last real-time sample reported for an entity in a previous run
+func (c *perfCollector) lastSample(e perfEntity) (time.Time, bool) {
+	var lastSample time.Time
+	if c.store == nil {
+		return lastSample, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.store.Get(lastSampleKey(e.ref), &lastSample)
+	return lastSample, err == nil
+}
+
+func lastSampleKey(ref types.ManagedObjectReference) string {
+	return "lastSample." + ref.Value
+}
+
+// processEntityMetric adds the values returned for an entity to its sample. Values of the aggregate
+// instance ("") go to the entity sample, values of other instances (a vCPU, disk, NIC...) are reported
+// on a sample per counter group and instance. Instances of counters that also have an aggregate value
+// are only reported with --perf_instance_metrics; counters without one, such as datastore.* or
+// virtualDisk.*, are always reported per instance.
+// When summarize is set every sample returned is reported as the average, minimum and maximum of the
+// series, otherwise only the latest sample is reported.
+func (c *perfCollector) processEntityMetric(entityType string, e perfEntity, ms *metric.Set, entityMetric *types.PerfEntityMetric, summarize bool) {
+	instanceMetricSets := make(map[string]*metric.Set)
+	aggregated := make(map[int32]bool)
+	for _, metricValue := range entityMetric.Value {
+		if series, ok := metricValue.(*types.PerfMetricIntSeries); ok && series.Id.Instance == "" {
+			aggregated[series.Id.CounterId] = true
+		}
+	}
+	for _, metricValue := range entityMetric.Value {
 		switch metricValueSeries := metricValue.(type) {
 
 		case *types.PerfMetricIntSeries:
 			//
-			counterInfo, ok := c.metricToNameMap[metricValueSeries.Id.CounterId]
-			if ok {
-				if len(metricValueSeries.Value) > 1 {
-					log.Warn("series contains more than one value %d \n", len(metricValueSeries.Value))
+			counterInfo, ok := c.metricToCounterMap[metricValueSeries.Id.CounterId]
+			if !ok || len(metricValueSeries.Value) == 0 {
+				continue
+			}
+
+			target := ms
+			cpus := c.cpuCount(e.ref)
+			if instance := metricValueSeries.Id.Instance; instance != "" {
+				if aggregated[counterInfo.Key] && !args.PerfInstanceMetrics {
+					continue
+				}
+				target = c.instanceMetricSet(instanceMetricSets, entityType, e, counterInfo.Name, instance)
+				// a single instance (e.g. one vCPU) is not shared across cpus
+				cpus = 0
+			}
+
+			for metricName, values := range seriesValues(counterInfo, metricValueSeries.Value, entityMetric.SampleInfo, cpus) {
+				if !summarize {
+					// the last value is the latest sample
+					err := target.SetMetric(metricName, values[len(values)-1], metric.GAUGE)
+					if err != nil {
+						log.Error(err.Error())
+					}
+					continue
+				}
+
+				avg, min, max := summarizeValues(values)
+				err := target.SetMetric(metricName, avg, metric.GAUGE)
+				if err != nil {
+					log.Error(err.Error())
 				}
-				if len(metricValueSeries.Value) > 0 {
-					err = ms.SetMetric(counterInfo, metricValueSeries.Value[0], metric.GAUGE)
+				for name, value := range map[string]float64{metricName + ".min": min, metricName + ".max": max} {
+					err := target.SetMetric(name, value, metric.GAUGE)
 					if err != nil {
 						log.Error(err.Error())
 					}
@@ -235,5 +548,24 @@ func (c *perfCollector) collectMetrics(entityType, nrEventType, name string, mor
 			log.Warn("unknown BasePerfMetricSeries type %T!\n", metricValueSeries)
 		}
 	}
-	return nil
+}
+
+// instanceMetricSet returns the sample of a counter's group for one instance of an entity, creating it on first use
+func (c *perfCollector) instanceMetricSet(metricSets map[string]*metric.Set, entityType string, e perfEntity, counterName string, instance string) *metric.Set {
+	group := strings.SplitN(counterName, ".", 2)[0]
+	key := group + "/" + instance
+	if ms, ok := metricSets[key]; ok {
+		return ms
+	}
+
+	groupName, ok := instanceSampleGroups[group]
+	if !ok {
+		groupName = strings.Title(group)
+	}
+	nrEventType := instanceSamplePrefixes[entityType] + groupName + "Sample"
+	ms := c.entities.newMetricSet(nrEventType, e.ref, e.name, metric.Attr("name", e.name), metric.Attr("instance", instance))
+	c.labels.decorate(ms, e.ref)
+
+	metricSets[key] = ms
+	return ms
 }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newTestPerfCollector returns a collector whose samples are written to the datacenter entity dc1
+func newTestPerfCollector(t *testing.T, counters ...counterMetadata) *perfCollector {
+	i, err := integration.New("test", "0.0.1", integration.InMemoryStore())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	entities, err := newEntityResolver(i, "dc1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	c := &perfCollector{entities: entities}
+	c.setCatalog(&counterCatalog{Counters: counters})
+	return c
+}
+
+// findMetricSet returns the sample of the datacenter entity with the given event type and attributes
+func findMetricSet(c *perfCollector, eventType string, attributes map[string]string) *metric.Set {
+	for _, ms := range c.entities.datacenter.Metrics {
+		if ms.Metrics["event_type"] != eventType {
+			continue
+		}
+		matches := true
+		for key, value := range attributes {
+			if ms.Metrics[key] != value {
+				matches = false
+			}
+		}
+		if matches {
+			return ms
+		}
+	}
+	return nil
+}
+
+func TestProcessEntityMetric(t *testing.T) {
+	defer func(saved bool) {
+		args.PerfInstanceMetrics = saved
+	}(args.PerfInstanceMetrics)
+
+	host := perfEntity{name: "esx1", ref: types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"}}
+	entityMetric := &types.PerfEntityMetric{
+		PerfEntityMetricBase: types.PerfEntityMetricBase{Entity: host.ref},
+		SampleInfo:           []types.PerfSampleInfo{{Interval: 20}, {Interval: 20}},
+		Value: []types.BasePerfMetricSeries{
+			&types.PerfMetricIntSeries{PerfMetricSeries: types.PerfMetricSeries{Id: types.PerfMetricId{CounterId: 1}}, Value: []int64{1000, 2000}},
+			&types.PerfMetricIntSeries{PerfMetricSeries: types.PerfMetricSeries{Id: types.PerfMetricId{CounterId: 1, Instance: "0"}}, Value: []int64{500, 3000}},
+			&types.PerfMetricIntSeries{PerfMetricSeries: types.PerfMetricSeries{Id: types.PerfMetricId{CounterId: 2, Instance: "ds-1"}}, Value: []int64{7, 9}},
+			&types.PerfMetricIntSeries{PerfMetricSeries: types.PerfMetricSeries{Id: types.PerfMetricId{CounterId: 3, Instance: "ds-1"}}, Value: []int64{4, 6}},
+			&types.PerfMetricIntSeries{PerfMetricSeries: types.PerfMetricSeries{Id: types.PerfMetricId{CounterId: 99}}, Value: []int64{1}},
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		instanceMetrics   bool
+		summarize         bool
+		expectedEntity    map[string]interface{}
+		expectedInstances map[string]map[string]interface{}
+	}{
+		{
+			name:           "latest sample",
+			expectedEntity: map[string]interface{}{"cpu.usage.average": float64(2000)},
+			expectedInstances: map[string]map[string]interface{}{
+				"ESXHostDatastoreSample/ds-1": {"datastore.read.average": float64(9), "datastore.write.average": float64(6)},
+			},
+		},
+		{
+			name:            "instance metrics",
+			instanceMetrics: true,
+			expectedEntity:  map[string]interface{}{"cpu.usage.average": float64(2000)},
+			expectedInstances: map[string]map[string]interface{}{
+				"ESXHostCpuSample/0":          {"cpu.usage.average": float64(3000)},
+				"ESXHostDatastoreSample/ds-1": {"datastore.read.average": float64(9), "datastore.write.average": float64(6)},
+			},
+		},
+		{
+			name:      "summarized samples",
+			summarize: true,
+			expectedEntity: map[string]interface{}{
+				"cpu.usage.average": float64(1500), "cpu.usage.average.min": float64(1000), "cpu.usage.average.max": float64(2000),
+			},
+			expectedInstances: map[string]map[string]interface{}{
+				"ESXHostDatastoreSample/ds-1": {
+					"datastore.read.average": float64(8), "datastore.read.average.min": float64(7), "datastore.read.average.max": float64(9),
+					"datastore.write.average": float64(5), "datastore.write.average.min": float64(4), "datastore.write.average.max": float64(6),
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		args.PerfInstanceMetrics = tc.instanceMetrics
+		c := newTestPerfCollector(t,
+			counterMetadata{Key: 1, Name: "cpu.usage.average", Unit: "percent"},
+			counterMetadata{Key: 2, Name: "datastore.read.average", Unit: "kiloBytesPerSecond"},
+			counterMetadata{Key: 3, Name: "datastore.write.average", Unit: "kiloBytesPerSecond"},
+		)
+		ms := c.newMetricSet("ESXHostSystemSample", host)
+		c.processEntityMetric("Host System", host, ms, entityMetric, tc.summarize)
+
+		for name, value := range tc.expectedEntity {
+			assert.Equal(t, value, ms.Metrics[name], "%s: %s", tc.name, name)
+		}
+		assert.Equal(t, "esx1", ms.Metrics["name"], tc.name)
+		assert.NotContains(t, ms.Metrics, "datastore.read.average", tc.name)
+
+		// the entity sample and one sample per instance
+		assert.Len(t, c.entities.datacenter.Metrics, 1+len(tc.expectedInstances), tc.name)
+		for key, expected := range tc.expectedInstances {
+			parts := strings.SplitN(key, "/", 2)
+			instanceSet := findMetricSet(c, parts[0], map[string]string{"name": "esx1", "instance": parts[1]})
+			if assert.NotNil(t, instanceSet, "%s: %s", tc.name, key) {
+				for name, value := range expected {
+					assert.Equal(t, value, instanceSet.Metrics[name], "%s: %s %s", tc.name, key, name)
+				}
+			}
+		}
+	}
+}
+
+func TestInstanceMetricSet(t *testing.T) {
+	c := newTestPerfCollector(t)
+	vm := perfEntity{name: "vm1", ref: types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}}
+	metricSets := make(map[string]*metric.Set)
+
+	disk := c.instanceMetricSet(metricSets, "Virtual Machine", vm, "virtualDisk.read.average", "scsi0:0")
+	assert.Equal(t, "ESXVirtualMachineVirtualDiskSample", disk.Metrics["event_type"])
+	assert.Equal(t, "vm1", disk.Metrics["name"])
+	assert.Equal(t, "scsi0:0", disk.Metrics["instance"])
+
+	assert.True(t, disk == c.instanceMetricSet(metricSets, "Virtual Machine", vm, "virtualDisk.write.average", "scsi0:0"))
+	assert.False(t, disk == c.instanceMetricSet(metricSets, "Virtual Machine", vm, "virtualDisk.read.average", "scsi0:1"))
+
+	rescpu := c.instanceMetricSet(metricSets, "Virtual Machine", vm, "rescpu.actav1.latest", "0")
+	assert.Equal(t, "ESXVirtualMachineRescpuSample", rescpu.Metrics["event_type"])
+	assert.Len(t, c.entities.datacenter.Metrics, 3)
+}
+
+// queryPerfStub answers QueryPerf calls with the values of the queried entities. A call querying a removed
+// entity fails with ManagedObjectNotFound, like vCenter does.
+type queryPerfStub struct {
+	values  map[string]int64
+	removed map[string]bool
+	// err fails every call
+	err error
+	// queries holds the entities of each QueryPerf call
+	queries [][]string
+}
+
+func (s *queryPerfStub) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	queried := make([]string, 0)
+	for _, querySpec := range req.(*methods.QueryPerfBody).Req.QuerySpec {
+		queried = append(queried, querySpec.Entity.Value)
+	}
+	s.queries = append(s.queries, queried)
+	if s.err != nil {
+		return s.err
+	}
+
+	returnval := make([]types.BasePerfEntityMetricBase, 0)
+	// entities are returned in reverse order, they are matched to the queried ones by reference
+	for i := len(queried) - 1; i >= 0; i-- {
+		if s.removed[queried[i]] {
+			return soapFault(types.ManagedObjectNotFound{})
+		}
+		entityMetric := &types.PerfEntityMetric{
+			PerfEntityMetricBase: types.PerfEntityMetricBase{Entity: types.ManagedObjectReference{Type: "VirtualMachine", Value: queried[i]}},
+		}
+		if value, ok := s.values[queried[i]]; ok {
+			entityMetric.SampleInfo = []types.PerfSampleInfo{{Interval: 20}}
+			entityMetric.Value = []types.BasePerfMetricSeries{
+				&types.PerfMetricIntSeries{PerfMetricSeries: types.PerfMetricSeries{Id: types.PerfMetricId{CounterId: 1}}, Value: []int64{value}},
+			}
+		}
+		returnval = append(returnval, entityMetric)
+	}
+	res.(*methods.QueryPerfBody).Res = &types.QueryPerfResponse{Returnval: returnval}
+	return nil
+}
+
+func newQueryPerfTestCollector(t *testing.T, stub *queryPerfStub) *perfCollector {
+	c := newTestPerfCollector(t, counterMetadata{Key: 1, Name: "cpu.usage.average", Unit: "percent"})
+	c.client = &govmomi.Client{Client: &vim25.Client{
+		RoundTripper:   stub,
+		ServiceContent: types.ServiceContent{PerfManager: &types.ManagedObjectReference{Type: "PerformanceManager", Value: "PerfMgr"}},
+	}}
+	return c
+}
+
+func testVMs(names ...string) []perfEntity {
+	vms := make([]perfEntity, 0, len(names))
+	for _, name := range names {
+		vms = append(vms, perfEntity{name: name, ref: types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-" + name}})
+	}
+	return vms
+}
+
+var testMetricIds = []types.PerfMetricId{{CounterId: 1, Instance: "*"}}
+
+func TestCollectMetrics(t *testing.T) {
+	stub := &queryPerfStub{values: map[string]int64{"vm-a": 100, "vm-b": 200}}
+	c := newQueryPerfTestCollector(t, stub)
+
+	err := c.collectMetrics("Virtual Machine", "ESXVirtualMachineSample", testVMs("a", "b", "c"), testMetricIds, perfInterval{id: 20, realTime: true})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"vm-a", "vm-b", "vm-c"}}, stub.queries)
+
+	// c returned no values, so it has no sample
+	assert.Len(t, c.entities.datacenter.Metrics, 2)
+	for name, value := range map[string]float64{"a": 100, "b": 200} {
+		ms := findMetricSet(c, "ESXVirtualMachineSample", map[string]string{"name": name})
+		if assert.NotNil(t, ms, name) {
+			assert.Equal(t, value, ms.Metrics["cpu.usage.average"], name)
+		}
+	}
+}
+
+func TestCollectBatchRemovedEntity(t *testing.T) {
+	stub := &queryPerfStub{values: map[string]int64{"vm-a": 100, "vm-c": 300}, removed: map[string]bool{"vm-b": true}}
+	c := newQueryPerfTestCollector(t, stub)
+
+	c.collectBatch("Virtual Machine", "ESXVirtualMachineSample", testVMs("a", "b", "c"), testMetricIds, perfInterval{id: 20, realTime: true})
+	assert.Equal(t, [][]string{{"vm-a", "vm-b", "vm-c"}, {"vm-a"}, {"vm-b"}, {"vm-c"}}, stub.queries)
+	assert.Equal(t, "", c.errors.summary("Virtual Machine"))
+
+	assert.Len(t, c.entities.datacenter.Metrics, 2)
+	for name, value := range map[string]float64{"a": 100, "c": 300} {
+		ms := findMetricSet(c, "ESXVirtualMachineSample", map[string]string{"name": name})
+		if assert.NotNil(t, ms, name) {
+			assert.Equal(t, value, ms.Metrics["cpu.usage.average"], name)
+		}
+	}
+
+	// other failures are counted without querying the entities again
+	stub = &queryPerfStub{err: soapFault(types.NoPermission{})}
+	c = newQueryPerfTestCollector(t, stub)
+	c.collectBatch("Virtual Machine", "ESXVirtualMachineSample", testVMs("a", "b"), testMetricIds, perfInterval{id: 20, realTime: true})
+	assert.Equal(t, [][]string{{"vm-a", "vm-b"}}, stub.queries)
+	assert.Equal(t, "1 permission", c.errors.summary("Virtual Machine"))
+	assert.Len(t, c.entities.datacenter.Metrics, 0)
+}
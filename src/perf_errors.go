@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Classes of the errors returned by performance queries
+const (
+	errorClassPermission      = "permission"
+	errorClassInvalidArgument = "invalid_argument"
+	errorClassNotFound        = "not_found"
+	errorClassTimeout         = "timeout"
+	errorClassOther           = "other"
+)
+
+// classifyError tells apart the causes of a failed vSphere API call, so that missing permissions or an
+// unreachable vCenter are not mistaken for entities that have no data at the configured statistics level
+func classifyError(err error) string {
+	if err == context.DeadlineExceeded {
+		return errorClassTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return errorClassTimeout
+	}
+	if !soap.IsSoapFault(err) {
+		return errorClassOther
+	}
+
+	switch soap.ToSoapFault(err).VimFault().(type) {
+	case types.NoPermission, types.NotAuthenticated, types.InvalidLogin:
+		return errorClassPermission
+	case types.InvalidArgument:
+		return errorClassInvalidArgument
+	case types.ManagedObjectNotFound:
+		return errorClassNotFound
+	}
+	return errorClassOther
+}
+
+// perfErrors counts the errors of the performance queries of each entity type by class
+type perfErrors struct {
+	counts map[string]map[string]int
+}
+
+func (e *perfErrors) add(entityType string, err error) {
+	if e.counts == nil {
+		e.counts = make(map[string]map[string]int)
+	}
+	classes, ok := e.counts[entityType]
+	if !ok {
+		classes = make(map[string]int)
+		e.counts[entityType] = classes
+	}
+	classes[classifyError(err)]++
+}
+
+// summary describes the errors of entityType, e.g. "2 permission, 1 timeout". It is empty when there were none.
+func (e *perfErrors) summary(entityType string) string {
+	classes := e.counts[entityType]
+	parts := make([]string, 0, len(classes))
+	for class, count := range classes {
+		parts = append(parts, fmt.Sprintf("%d %s", count, class))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
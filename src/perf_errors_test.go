@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func soapFault(fault types.AnyType) error {
+	f := &soap.Fault{}
+	f.Detail.Fault = fault
+	return soap.WrapSoapFault(f)
+}
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err           error
+		expectedClass string
+	}{
+		{soapFault(types.NoPermission{}), errorClassPermission},
+		{soapFault(types.NotAuthenticated{}), errorClassPermission},
+		{soapFault(types.InvalidArgument{}), errorClassInvalidArgument},
+		{soapFault(types.ManagedObjectNotFound{}), errorClassNotFound},
+		{soapFault(types.SystemError{}), errorClassOther},
+		{context.DeadlineExceeded, errorClassTimeout},
+		{errors.New("connection refused"), errorClassOther},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedClass, classifyError(tc.err))
+	}
+}
+
+func TestPerfErrorsSummary(t *testing.T) {
+	var e perfErrors
+	assert.Equal(t, "", e.summary("Host System"))
+
+	e.add("Host System", soapFault(types.NoPermission{}))
+	e.add("Host System", soapFault(types.NoPermission{}))
+	e.add("Host System", context.DeadlineExceeded)
+	e.add("Virtual Machine", soapFault(types.InvalidArgument{}))
+
+	assert.Equal(t, "1 timeout, 2 permission", e.summary("Host System"))
+	assert.Equal(t, "1 invalid_argument", e.summary("Virtual Machine"))
+}
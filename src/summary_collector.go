@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"time"
 
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/units"
@@ -9,17 +10,22 @@ import (
 	"github.com/vmware/govmomi/view"
 
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
-	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
+// alarmEntityTypes are the managed entity types whose triggered alarms are reported
+var alarmEntityTypes = []string{"ClusterComputeResource", "HostSystem", "VirtualMachine", "Datastore"}
+
 type summaryCollector struct {
-	client *govmomi.Client
-	entity *integration.Entity
-	dc     *object.Datacenter
+	client   *govmomi.Client
+	entities *entityResolver
+	dc       *object.Datacenter
+	labels   *entityLabels
+	// selection holds the objects selected by the entity filters
+	selection *entitySelection
 }
 
 func (c *summaryCollector) collectHostMetrics(nrEventType string) error {
@@ -46,12 +52,16 @@ func (c *summaryCollector) collectHostMetrics(nrEventType string) error {
 	}
 
 	for _, hs := range hss {
+		if !c.selection.selects(hs.Self) {
+			continue
+		}
 		hsName := hs.Summary.Config.Name
-		ms := c.entity.NewMetricSet(nrEventType)
+		ms := c.entities.newMetricSet(nrEventType, hs.Self, hsName)
 		err := ms.SetMetric("name", hsName, metric.ATTRIBUTE)
 		if err != nil {
 			log.Error(err.Error())
 		}
+		c.labels.decorate(ms, hs.Self)
 
 		totalCPU := int64(hs.Summary.Hardware.CpuMhz) * int64(hs.Summary.Hardware.NumCpuCores)
 		freeCPU := int64(totalCPU) - int64(hs.Summary.QuickStats.OverallCpuUsage)
@@ -95,12 +105,16 @@ func (c *summaryCollector) collectDSMetrics(nrEventType string) error {
 	}
 
 	for _, ds := range dss {
+		if !c.selection.selects(ds.Self) {
+			continue
+		}
 		dsName := ds.Summary.Name
-		ms := c.entity.NewMetricSet(nrEventType)
+		ms := c.entities.newMetricSet(nrEventType, ds.Self, dsName)
 		err := ms.SetMetric("name", dsName, metric.ATTRIBUTE)
 		if err != nil {
 			log.Error(err.Error())
 		}
+		c.labels.decorate(ms, ds.Self)
 
 		_ = ms.SetMetric("ds.type", ds.Summary.Type, metric.ATTRIBUTE)
 		_ = ms.SetMetric("ds.url", ds.Summary.Url, metric.ATTRIBUTE)
@@ -142,9 +156,13 @@ func (c *summaryCollector) collectVMMetrics(nrEventType string) error {
 	}
 
 	for _, vm := range vms {
+		if !c.selection.selects(vm.Self) {
+			continue
+		}
 		vmConfig := vm.Summary.Config
-		ms := c.entity.NewMetricSet(nrEventType)
+		ms := c.entities.newMetricSet(nrEventType, vm.Self, vmConfig.Name)
 		_ = ms.SetMetric("name", vmConfig.Name, metric.ATTRIBUTE)
+		c.labels.decorate(ms, vm.Self)
 
 		_ = ms.SetMetric("guestFullName", vmConfig.GuestFullName, metric.GAUGE)
 		_ = ms.SetMetric("memorySize", vmConfig.MemorySizeMB, metric.GAUGE)
@@ -197,18 +215,154 @@ func (c *summaryCollector) collectResourcePoolMetrics(nrEventType string) error
 	}()
 
 	var rps []mo.ResourcePool
-	err = view.Retrieve(ctx, []string{"ResourcePool"}, []string{"summary"}, &rps)
+	err = view.Retrieve(ctx, []string{"ResourcePool"}, []string{"name", "summary"}, &rps)
 	if err != nil {
 		return err
 	}
 
 	for _, rp := range rps {
+		if !c.selection.selects(rp.Self) {
+			continue
+		}
 		rpName := rp.Name
-		ms := c.entity.NewMetricSet(nrEventType)
+		ms := c.entities.newMetricSet(nrEventType, rp.Self, rpName)
 		err := ms.SetMetric("name", rpName, metric.ATTRIBUTE)
 		if err != nil {
 			log.Error(err.Error())
 		}
+		c.labels.decorate(ms, rp.Self)
+	}
+	return nil
+}
+
+func (c *summaryCollector) collectClusterMetrics(nrEventType string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Create a view of ClusterComputeResource objects
+	manager := view.NewManager(c.client.Client)
+
+	view, err := manager.CreateContainerView(ctx, c.dc.Reference(), []string{"ClusterComputeResource"}, true)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := view.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	var cls []mo.ClusterComputeResource
+	err = view.Retrieve(ctx, []string{"ClusterComputeResource"}, []string{"name", "summary", "configurationEx"}, &cls)
+	if err != nil {
+		return err
+	}
+
+	for _, cl := range cls {
+		if !c.selection.selects(cl.Self) {
+			continue
+		}
+		ms := c.entities.newMetricSet(nrEventType, cl.Self, cl.Name)
+		err := ms.SetMetric("name", cl.Name, metric.ATTRIBUTE)
+		if err != nil {
+			log.Error(err.Error())
+		}
+		c.labels.decorate(ms, cl.Self)
+
+		setSummaryMetrics(ms, clusterSummaryAttributes(cl))
+	}
+	return nil
+}
+
+func (c *summaryCollector) collectAlarms(nrEventType string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Create a view of the entities alarms can be triggered on
+	manager := view.NewManager(c.client.Client)
+
+	view, err := manager.CreateContainerView(ctx, c.dc.Reference(), alarmEntityTypes, true)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := view.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	var managedEntities []mo.ManagedEntity
+	err = view.Retrieve(ctx, alarmEntityTypes, []string{"name", "triggeredAlarmState"}, &managedEntities)
+	if err != nil {
+		return err
+	}
+
+	// The container view does not include the datacenter itself
+	var dc mo.Datacenter
+	err = c.client.RetrieveOne(ctx, c.dc.Reference(), []string{"name", "triggeredAlarmState"}, &dc)
+	if err != nil {
+		return err
+	}
+	managedEntities = append(managedEntities, dc.ManagedEntity)
+
+	// An entity's triggeredAlarmState also lists the alarms of its descendants
+	entityNames := make(map[string]string)
+	alarmStates := make([]types.AlarmState, 0)
+	seenStates := make(map[string]bool)
+	alarmRefs := make([]types.ManagedObjectReference, 0)
+	seenAlarms := make(map[string]bool)
+	for _, entity := range managedEntities {
+		entityNames[entity.Self.Value] = entity.Name
+		for _, state := range entity.TriggeredAlarmState {
+			if seenStates[state.Key] || !c.selection.selects(state.Entity) || entityTypeMode(state.Entity.Type) == modeOff {
+				continue
+			}
+			if state.OverallStatus != types.ManagedEntityStatusRed && state.OverallStatus != types.ManagedEntityStatusYellow {
+				continue
+			}
+			seenStates[state.Key] = true
+			alarmStates = append(alarmStates, state)
+			if !seenAlarms[state.Alarm.Value] {
+				seenAlarms[state.Alarm.Value] = true
+				alarmRefs = append(alarmRefs, state.Alarm)
+			}
+		}
+	}
+	if len(alarmStates) == 0 {
+		return nil
+	}
+
+	var alarms []mo.Alarm
+	err = c.client.Retrieve(ctx, alarmRefs, []string{"info.name"}, &alarms)
+	if err != nil {
+		return err
+	}
+	alarmNames := make(map[string]string)
+	for _, alarm := range alarms {
+		alarmNames[alarm.Self.Value] = alarm.Info.Name
+	}
+
+	for _, state := range alarmStates {
+		entityName, ok := entityNames[state.Entity.Value]
+		if !ok {
+			entityName = state.Entity.Value
+		}
+		ms := c.entities.newMetricSet(nrEventType, state.Entity, entityName)
+		err := ms.SetMetric("alarmName", alarmNames[state.Alarm.Value], metric.ATTRIBUTE)
+		if err != nil {
+			log.Error(err.Error())
+		}
+
+		_ = ms.SetMetric("entityName", entityName, metric.ATTRIBUTE)
+		_ = ms.SetMetric("entityType", state.Entity.Type, metric.ATTRIBUTE)
+		_ = ms.SetMetric("overallStatus", string(state.OverallStatus), metric.ATTRIBUTE)
+		_ = ms.SetMetric("triggeredTime", state.Time.Format(time.RFC3339), metric.ATTRIBUTE)
+
+		acknowledged := 0
+		if state.Acknowledged != nil && *state.Acknowledged {
+			acknowledged = 1
+		}
+		_ = ms.SetMetric("acknowledged", acknowledged, metric.GAUGE)
 	}
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 
 	"github.com/vmware/govmomi/object"
 
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/view"
@@ -12,6 +13,8 @@ import (
 	"github.com/vmware/govmomi/vim25/types"
 )
 
+// Summary attributes are indexed by the managed object reference value of the entity they belong to
+
 func collectDatastoreSummaryAttributes(client *govmomi.Client, dc *object.Datacenter) (map[string]map[string]interface{}, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -37,11 +40,11 @@ func collectDatastoreSummaryAttributes(client *govmomi.Client, dc *object.Datace
 	}
 
 	for _, ds := range dss {
-		dsName := ds.Summary.Name
-		dsMetrics, ok := dsSummary[dsName]
+		dsRef := ds.Self.Value
+		dsMetrics, ok := dsSummary[dsRef]
 		if !ok {
 			dsMetrics = make(map[string]interface{})
-			dsSummary[dsName] = dsMetrics
+			dsSummary[dsRef] = dsMetrics
 		}
 		dsMetrics["ds.type"] = ds.Summary.Type
 		dsMetrics["ds.url"] = ds.Summary.Url
@@ -58,3 +61,228 @@ func collectDatastoreSummaryAttributes(client *govmomi.Client, dc *object.Datace
 	}
 	return dsSummary, nil
 }
+
+func collectClusterSummaryAttributes(client *govmomi.Client, dc *object.Datacenter) (map[string]map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clSummary := make(map[string]map[string]interface{})
+	// Create a view of ClusterComputeResource objects
+	manager := view.NewManager(client.Client)
+
+	view, err := manager.CreateContainerView(ctx, dc.Reference(), []string{"ClusterComputeResource"}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := view.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	var cls []mo.ClusterComputeResource
+	err = view.Retrieve(ctx, []string{"ClusterComputeResource"}, []string{"name", "summary", "configurationEx"}, &cls)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cl := range cls {
+		clSummary[cl.Self.Value] = clusterSummaryAttributes(cl)
+	}
+	return clSummary, nil
+}
+
+func clusterSummaryAttributes(cl mo.ClusterComputeResource) map[string]interface{} {
+	clMetrics := make(map[string]interface{})
+
+	if cl.Summary != nil {
+		summary := cl.Summary.GetComputeResourceSummary()
+		clMetrics["cluster.totalCpu"] = summary.TotalCpu
+		clMetrics["cluster.totalMemory"] = summary.TotalMemory
+		clMetrics["cluster.effectiveCpu"] = summary.EffectiveCpu
+		clMetrics["cluster.effectiveMemory"] = summary.EffectiveMemory * 1024 * 1024
+		clMetrics["cluster.numHosts"] = summary.NumHosts
+		clMetrics["cluster.numEffectiveHosts"] = summary.NumEffectiveHosts
+		clMetrics["cluster.overallStatus"] = string(summary.OverallStatus)
+
+		if clusterSummary, ok := cl.Summary.(*types.ClusterComputeResourceSummary); ok {
+			clMetrics["cluster.currentBalance"] = clusterSummary.CurrentBalance
+			clMetrics["cluster.targetBalance"] = clusterSummary.TargetBalance
+			clMetrics["cluster.currentFailoverLevel"] = clusterSummary.CurrentFailoverLevel
+		}
+	}
+
+	if config, ok := cl.ConfigurationEx.(*types.ClusterConfigInfoEx); ok {
+		clMetrics["cluster.drsEnabled"] = config.DrsConfig.Enabled != nil && *config.DrsConfig.Enabled
+		clMetrics["cluster.drsBehavior"] = string(config.DrsConfig.DefaultVmBehavior)
+		clMetrics["cluster.haEnabled"] = config.DasConfig.Enabled != nil && *config.DasConfig.Enabled
+		clMetrics["cluster.admissionControlEnabled"] = config.DasConfig.AdmissionControlEnabled != nil && *config.DasConfig.AdmissionControlEnabled
+
+		switch config.DasConfig.AdmissionControlPolicy.(type) {
+		case *types.ClusterFailoverLevelAdmissionControlPolicy:
+			clMetrics["cluster.admissionControlPolicy"] = "failoverLevel"
+		case *types.ClusterFailoverResourcesAdmissionControlPolicy:
+			clMetrics["cluster.admissionControlPolicy"] = "failoverResources"
+		case *types.ClusterFailoverHostAdmissionControlPolicy:
+			clMetrics["cluster.admissionControlPolicy"] = "failoverHosts"
+		}
+	}
+	return clMetrics
+}
+
+// collectHostSummaryAttributes returns the power state, overall status, memory size, CPU counts and cluster of each host
+func collectHostSummaryAttributes(client *govmomi.Client, dc *object.Datacenter) (map[string]map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hsSummary := make(map[string]map[string]interface{})
+	// Create a view of HostSystem objects and the clusters they belong to
+	manager := view.NewManager(client.Client)
+
+	view, err := manager.CreateContainerView(ctx, dc.Reference(), []string{"HostSystem", "ClusterComputeResource"}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := view.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	var hss []mo.HostSystem
+	err = view.Retrieve(ctx, []string{"HostSystem"}, []string{"summary", "parent"}, &hss)
+	if err != nil {
+		return nil, err
+	}
+	clusterNames, err := retrieveClusterNames(ctx, view)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hs := range hss {
+		hsMetrics := make(map[string]interface{})
+		hsMetrics["powerState"] = string(hs.Summary.Runtime.PowerState)
+		hsMetrics["overallStatus"] = string(hs.Summary.OverallStatus)
+		if hardware := hs.Summary.Hardware; hardware != nil {
+			hsMetrics["memorySize"] = hardware.MemorySize
+			hsMetrics["numCpuCores"] = int32(hardware.NumCpuCores)
+			hsMetrics["numCpuThreads"] = int32(hardware.NumCpuThreads)
+		}
+		if hs.Parent != nil {
+			if clusterName, ok := clusterNames[hs.Parent.Value]; ok {
+				hsMetrics["clusterName"] = clusterName
+			}
+		}
+		hsSummary[hs.Self.Value] = hsMetrics
+	}
+	return hsSummary, nil
+}
+
+// collectVMSummaryAttributes returns the power state, overall status, memory size, vCPU count, guest OS, host and cluster of each virtual machine
+func collectVMSummaryAttributes(client *govmomi.Client, dc *object.Datacenter) (map[string]map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vmSummary := make(map[string]map[string]interface{})
+	// Create a view of VirtualMachine objects and the hosts and clusters they run on
+	manager := view.NewManager(client.Client)
+
+	view, err := manager.CreateContainerView(ctx, dc.Reference(), []string{"VirtualMachine", "HostSystem", "ClusterComputeResource"}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := view.Destroy(ctx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
+	var vms []mo.VirtualMachine
+	err = view.Retrieve(ctx, []string{"VirtualMachine"}, []string{"summary"}, &vms)
+	if err != nil {
+		return nil, err
+	}
+	var hss []mo.HostSystem
+	err = view.Retrieve(ctx, []string{"HostSystem"}, []string{"name", "parent"}, &hss)
+	if err != nil {
+		return nil, err
+	}
+	clusterNames, err := retrieveClusterNames(ctx, view)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]mo.HostSystem)
+	for _, hs := range hss {
+		hosts[hs.Self.Value] = hs
+	}
+
+	for _, vm := range vms {
+		vmConfig := vm.Summary.Config
+		vmMetrics := make(map[string]interface{})
+		vmMetrics["overallStatus"] = string(vm.Summary.OverallStatus)
+		vmMetrics["memorySize"] = vmConfig.MemorySizeMB
+		vmMetrics["numCpu"] = vmConfig.NumCpu
+		vmMetrics["guestFullName"] = vmConfig.GuestFullName
+
+		switch vm.Summary.Runtime.PowerState {
+		case types.VirtualMachinePowerStatePoweredOff:
+			vmMetrics["powerState"] = 0
+		case types.VirtualMachinePowerStatePoweredOn:
+			vmMetrics["powerState"] = 2
+		case types.VirtualMachinePowerStateSuspended:
+			vmMetrics["powerState"] = 1
+		}
+
+		if vm.Summary.Runtime.Host != nil {
+			if hs, ok := hosts[vm.Summary.Runtime.Host.Value]; ok {
+				vmMetrics["hostName"] = hs.Name
+				if hs.Parent != nil {
+					if clusterName, ok := clusterNames[hs.Parent.Value]; ok {
+						vmMetrics["clusterName"] = clusterName
+					}
+				}
+			}
+		}
+		vmSummary[vm.Self.Value] = vmMetrics
+	}
+	return vmSummary, nil
+}
+
+// retrieveClusterNames returns the names of the clusters in v indexed by their reference value
+func retrieveClusterNames(ctx context.Context, v *view.ContainerView) (map[string]string, error) {
+	var cls []mo.ClusterComputeResource
+	err := v.Retrieve(ctx, []string{"ClusterComputeResource"}, []string{"name"}, &cls)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, cl := range cls {
+		names[cl.Self.Value] = cl.Name
+	}
+	return names, nil
+}
+
+// setSummaryMetrics adds summary attributes to a metric set. Strings are reported as attributes,
+// booleans as 0/1 gauges and numbers as gauges.
+func setSummaryMetrics(ms *metric.Set, summaryMetrics map[string]interface{}) {
+	for k, v := range summaryMetrics {
+		var err error
+		switch tv := v.(type) {
+		case string:
+			err = ms.SetMetric(k, tv, metric.ATTRIBUTE)
+		case bool:
+			var val int
+			if tv {
+				val = 1
+			}
+			err = ms.SetMetric(k, val, metric.GAUGE)
+		case int, int64, int32, float32, float64:
+			err = ms.SetMetric(k, tv, metric.GAUGE)
+		default:
+			log.Error("unknown metric value datatype %T", v)
+		}
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// tagClient reads vSphere tags through the vCenter tagging REST API. Tags and categories
+// are cached for the lifetime of the client so each one is only requested once per run.
+type tagClient struct {
+	baseURL    string
+	httpClient *http.Client
+	sessionID  string
+
+	tags       map[string]tagInfo
+	categories map[string]string
+}
+
+type tagInfo struct {
+	Name       string `json:"name"`
+	CategoryID string `json:"category_id"`
+}
+
+type tagObjectID struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+type tagAssociation struct {
+	ObjectID tagObjectID `json:"object_id"`
+	TagIds   []string    `json:"tag_ids"`
+}
+
+// newTagClient logs in to the REST API of the vCenter serving the SDK at vmURL
+func newTagClient(vmURL string, vmUsername string, vmPassword string, insecure bool) (*tagClient, error) {
+	sdkURL, err := soap.ParseURL(vmURL)
+	if err != nil {
+		return nil, err
+	}
+	setCredentials(sdkURL, vmUsername, vmPassword)
+
+	base := url.URL{Scheme: sdkURL.Scheme, Host: sdkURL.Host, Path: "/rest/com/vmware/cis"}
+	c := &tagClient{
+		baseURL: base.String(),
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+		tags:       make(map[string]tagInfo),
+		categories: make(map[string]string),
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/session", nil)
+	if err != nil {
+		return nil, err
+	}
+	if sdkURL.User != nil {
+		password, _ := sdkURL.User.Password()
+		req.SetBasicAuth(sdkURL.User.Username(), password)
+	}
+	var sessionID string
+	err = c.do(req, &sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in to the tagging API: %v", err)
+	}
+	c.sessionID = sessionID
+	return c, nil
+}
+
+func (c *tagClient) logout() {
+	req, err := http.NewRequest("DELETE", c.baseURL+"/session", nil)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	err = c.do(req, nil)
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// attachedTags returns the tags attached to refs as category name to tag names, indexed by reference value
+func (c *tagClient) attachedTags(refs []types.ManagedObjectReference) (map[string]map[string][]string, error) {
+	objectIDs := make([]tagObjectID, 0, len(refs))
+	for _, ref := range refs {
+		objectIDs = append(objectIDs, tagObjectID{ID: ref.Value, Type: ref.Type})
+	}
+	body, err := json.Marshal(map[string]interface{}{"object_ids": objectIDs})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.baseURL+"/tagging/tag-association?~action=list-attached-tags-on-objects", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var associations []tagAssociation
+	err = c.do(req, &associations)
+	if err != nil {
+		return nil, err
+	}
+
+	attached := make(map[string]map[string][]string)
+	for _, association := range associations {
+		for _, tagID := range association.TagIds {
+			tag, category, err := c.tag(tagID)
+			if err != nil {
+				log.Warn("unable to read tag %s: %v", tagID, err)
+				continue
+			}
+			objectTags, ok := attached[association.ObjectID.ID]
+			if !ok {
+				objectTags = make(map[string][]string)
+				attached[association.ObjectID.ID] = objectTags
+			}
+			objectTags[category] = append(objectTags[category], tag)
+		}
+	}
+	return attached, nil
+}
+
+// tag returns the name and category name of a tag
+func (c *tagClient) tag(id string) (string, string, error) {
+	tag, ok := c.tags[id]
+	if !ok {
+		req, err := http.NewRequest("GET", c.baseURL+"/tagging/tag/id:"+url.PathEscape(id), nil)
+		if err != nil {
+			return "", "", err
+		}
+		err = c.do(req, &tag)
+		if err != nil {
+			return "", "", err
+		}
+		c.tags[id] = tag
+	}
+
+	category, ok := c.categories[tag.CategoryID]
+	if !ok {
+		var info struct {
+			Name string `json:"name"`
+		}
+		req, err := http.NewRequest("GET", c.baseURL+"/tagging/category/id:"+url.PathEscape(tag.CategoryID), nil)
+		if err != nil {
+			return "", "", err
+		}
+		err = c.do(req, &info)
+		if err != nil {
+			return "", "", err
+		}
+		category = info.Name
+		c.categories[tag.CategoryID] = category
+	}
+	return tag.Name, category, nil
+}
+
+// do sends req and decodes the "value" member of the response into value
+func (c *tagClient) do(req *http.Request, value interface{}) error {
+	if c.sessionID != "" {
+		req.Header.Set("vmware-api-session-id", c.sessionID)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// tags are optional, failing to close a response must not stop the run
+		if err := resp.Body.Close(); err != nil {
+			log.Warn("unable to close the response of %s %s: %v", req.Method, req.URL.Path, err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, msg)
+	}
+	if value == nil {
+		return nil
+	}
+	response := struct {
+		Value interface{} `json:"value"`
+	}{Value: value}
+	return json.NewDecoder(resp.Body).Decode(&response)
+}
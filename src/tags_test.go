@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newTagServer serves the session, tag association, tag and category calls of the vCenter tagging REST API,
+// counting the requests of each path
+func newTagServer(t *testing.T, requests map[string]int) *httptest.Server {
+	reply := func(w http.ResponseWriter, value interface{}) {
+		err := json.NewEncoder(w).Encode(map[string]interface{}{"value": value})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method+" "+r.URL.Path]++
+		if r.URL.Path == "/rest/com/vmware/cis/session" && r.Method == "POST" {
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "admin" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			reply(w, "session-1")
+			return
+		}
+		if r.Header.Get("vmware-api-session-id") != "session-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "DELETE /rest/com/vmware/cis/session":
+			w.WriteHeader(http.StatusOK)
+		case "POST /rest/com/vmware/cis/tagging/tag-association":
+			assert.Equal(t, "list-attached-tags-on-objects", r.URL.Query().Get("~action"))
+			var body struct {
+				ObjectIDs []tagObjectID `json:"object_ids"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			associations := make([]tagAssociation, 0)
+			for _, objectID := range body.ObjectIDs {
+				associations = append(associations, tagAssociation{ObjectID: objectID, TagIds: []string{"urn:tag:prod", "urn:tag:web"}})
+			}
+			reply(w, associations)
+		case "GET /rest/com/vmware/cis/tagging/tag/id:urn:tag:prod":
+			reply(w, tagInfo{Name: "prod", CategoryID: "urn:category:env"})
+		case "GET /rest/com/vmware/cis/tagging/tag/id:urn:tag:web":
+			reply(w, tagInfo{Name: "web", CategoryID: "urn:category:role"})
+		case "GET /rest/com/vmware/cis/tagging/category/id:urn:category:env":
+			reply(w, map[string]string{"name": "env"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestTagClient(t *testing.T) {
+	requests := make(map[string]int)
+	server := newTagServer(t, requests)
+	defer server.Close()
+
+	_, err := newTagClient(server.URL+"/sdk", "admin", "wrong", true)
+	assert.Error(t, err)
+
+	client, err := newTagClient(server.URL+"/sdk", "admin", "secret", true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "session-1", client.sessionID)
+
+	refs := []types.ManagedObjectReference{
+		{Type: "VirtualMachine", Value: "vm-1"},
+		{Type: "HostSystem", Value: "host-1"},
+	}
+	attached, err := client.attachedTags(refs)
+	assert.NoError(t, err)
+	// the role category cannot be read, so the web tag is left out
+	assert.Equal(t, map[string]map[string][]string{
+		"vm-1":   {"env": {"prod"}},
+		"host-1": {"env": {"prod"}},
+	}, attached)
+
+	// tags and categories are only requested once
+	_, err = client.attachedTags(refs)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests["GET /rest/com/vmware/cis/tagging/tag/id:urn:tag:prod"])
+	assert.Equal(t, 1, requests["GET /rest/com/vmware/cis/tagging/category/id:urn:category:env"])
+	assert.Equal(t, 2, requests["POST /rest/com/vmware/cis/tagging/tag-association"])
+
+	client.logout()
+	assert.Equal(t, 1, requests["DELETE /rest/com/vmware/cis/session"])
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// configEntityTypes are the counter lists of the config file checked by --validate_config, whatever the collection
+// mode of their entity type. Counters of historical entity types must be collected at the statistics level of the
+// historical interval they are queried from.
+var configEntityTypes = []struct {
+	name       string
+	counters   *[]string
+	historical bool
+	mode       *collectionMode
+}{
+	{"Host System", &hostCounters, false, &hostMode},
+	{"Virtual Machine", &vmCounters, false, &vmMode},
+	{"Resource Pool", &rpoolCounters, true, &rpoolMode},
+	{"Datastore", &dsCounters, true, &dsMode},
+	{"Cluster Compute Resource", &clCounters, true, &clMode},
+}
+
+// validateConfig checks the configured counters against the counter catalog, read from --counter_catalog_file
+// or from vCenter, and logs a report of the invalid ones. It returns the exit code of the integration.
+func validateConfig(url string, username string, password string, validateSSL bool) int {
+	collector := &perfCollector{}
+	if args.CounterCatalogFile != "" {
+		catalog, err := readCounterCatalogFile(args.CounterCatalogFile)
+		if err != nil {
+			log.Error("unable to read counter catalog %s: %v", args.CounterCatalogFile, err)
+			return 1
+		}
+		collector.setCatalog(catalog)
+	} else {
+		client, err := newClient(url, username, password, validateSSL)
+		if err != nil {
+			log.Error("unable to create client for " + url)
+			log.Error(err.Error())
+			return 3
+		}
+		defer logout(client)
+
+		collector.client = client
+		err = collector.initCounterMetadata(hostCounters, vmCounters, rpoolCounters, dsCounters, clCounters)
+		if err != nil {
+			log.Error(err.Error())
+			return 5
+		}
+	}
+
+	valid := true
+	for _, entityType := range configEntityTypes {
+		problems := collector.validateCounters(*entityType.counters, entityType.historical)
+		for _, problem := range problems {
+			log.Error("%s: %s", entityType.name, problem)
+		}
+		valid = valid && len(problems) == 0
+	}
+	if !valid {
+		return 6
+	}
+	log.Info("all configured counters are valid")
+	return 0
+}
+
+// validateCounters returns the problems of the entries of a counter list: counters that do not exist, counters
+// whose rollup does not exist and, for historical entity types, counters above the statistics level. Levels are
+// only checked for the counters left once the "!" entries are excluded.
+func (c *perfCollector) validateCounters(counterList []string, historical bool) []string {
+	problems := make([]string, 0)
+	for _, entry := range counterList {
+		entry = strings.TrimSpace(entry)
+		if strings.HasPrefix(entry, "!") {
+			continue
+		}
+		if len(matchCounters(c.nameToMetricMap, entry)) == 0 {
+			problems = append(problems, c.unknownCounterProblem(entry))
+		}
+	}
+
+	level := c.statisticsLevel()
+	if !historical || level == 0 {
+		return problems
+	}
+	counterIDs, _ := resolveCounters(c.nameToMetricMap, counterList)
+	aboveLevel := make([]string, 0)
+	for _, counterID := range counterIDs {
+		counter := c.metricToCounterMap[counterID]
+		if counter.Level > level {
+			aboveLevel = append(aboveLevel, fmt.Sprintf("%s is collected from statistics level %d, the statistics level is %d", counter.Name, counter.Level, level))
+		}
+	}
+	sort.Strings(aboveLevel)
+	return append(problems, aboveLevel...)
+}
+
+// unknownCounterProblem describes an entry that matched no counter, listing the rollups of the counter if only the rollup is wrong
+func (c *perfCollector) unknownCounterProblem(entry string) string {
+	separator := strings.LastIndex(entry, ".")
+	if separator < 0 || strings.ContainsAny(entry, "*?[/") {
+		return fmt.Sprintf("%s matches no counter", entry)
+	}
+
+	rollups := make([]string, 0)
+	for _, counter := range c.metricToCounterMap {
+		if counter.Name == entry[:separator]+"."+counter.Rollup {
+			rollups = append(rollups, counter.Rollup)
+		}
+	}
+	if len(rollups) == 0 {
+		return fmt.Sprintf("unknown counter %s", entry)
+	}
+	sort.Strings(rollups)
+	return fmt.Sprintf("counter %s has no %s rollup, available rollups: %s", entry[:separator], entry[separator+1:], strings.Join(rollups, ", "))
+}
+
+// statisticsLevel returns the level of the historical interval performance metrics are queried from, or 0 when none is enabled
+func (c *perfCollector) statisticsLevel() int32 {
+	var samplingPeriod, level int32
+	for _, historicalInterval := range c.historicalIntervals {
+		if historicalInterval.Enabled && (samplingPeriod == 0 || historicalInterval.SamplingPeriod < samplingPeriod) {
+			samplingPeriod = historicalInterval.SamplingPeriod
+			level = historicalInterval.Level
+		}
+	}
+	return level
+}
+
+// exportCounterCatalog writes the counter catalog of vCenter to file, to validate config files offline with --counter_catalog_file
+func exportCounterCatalog(url string, username string, password string, validateSSL bool, file string) error {
+	client, err := newClient(url, username, password, validateSSL)
+	if err != nil {
+		return fmt.Errorf("unable to create client for %s: %v", url, err)
+	}
+	defer logout(client)
+
+	catalog, err := loadCounterCatalog(client, true)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, data, 0644)
+	if err != nil {
+		return err
+	}
+	log.Info("exported %d counters to %s", len(catalog.Counters), file)
+	return nil
+}
+
+func readCounterCatalogFile(file string) (*counterCatalog, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var catalog counterCatalog
+	err = json.Unmarshal(data, &catalog)
+	if err != nil {
+		return nil, err
+	}
+	return &catalog, nil
+}
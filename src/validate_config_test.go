@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestValidateCounters(t *testing.T) {
+	collector := &perfCollector{}
+	collector.setCatalog(&counterCatalog{
+		Counters: []counterMetadata{
+			{Key: 1, Name: "cpu.usage.average", Rollup: "average", Level: 1},
+			{Key: 2, Name: "cpu.usage.maximum", Rollup: "maximum", Level: 4},
+			{Key: 3, Name: "cpu.ready.summation", Rollup: "summation", Level: 1},
+			{Key: 4, Name: "disk.used.latest", Rollup: "latest", Level: 1},
+			{Key: 5, Name: "disk.provisioned.latest", Rollup: "latest", Level: 1},
+		},
+		HistoricalIntervals: []types.PerfInterval{
+			{SamplingPeriod: 300, Level: 1, Enabled: true},
+			{SamplingPeriod: 1800, Level: 3, Enabled: true},
+		},
+	})
+
+	problems := collector.validateCounters([]string{"cpu.usage.average", "cpu.usage.*", "!cpu.usage.maximum"}, false)
+	assert.Equal(t, []string{}, problems)
+
+	problems = collector.validateCounters([]string{"cpu.usage.summation", "cpu.unknown.average", "net.*", "disk.*"}, true)
+	assert.Equal(t, []string{
+		"counter cpu.usage has no summation rollup, available rollups: average, maximum",
+		"unknown counter cpu.unknown.average",
+		"net.* matches no counter",
+	}, problems)
+
+	problems = collector.validateCounters([]string{"cpu.usage.*"}, true)
+	assert.Equal(t, []string{"cpu.usage.maximum is collected from statistics level 4, the statistics level is 1"}, problems)
+
+	problems = collector.validateCounters([]string{"cpu.usage.*", "!cpu.usage.maximum"}, true)
+	assert.Equal(t, []string{}, problems)
+
+	problems = collector.validateCounters([]string{"cpu.*", "!cpu.ready.*", "disk.unknown.latest"}, true)
+	assert.Equal(t, []string{
+		"unknown counter disk.unknown.latest",
+		"cpu.usage.maximum is collected from statistics level 4, the statistics level is 1",
+	}, problems)
+}
@@ -15,19 +15,36 @@ type argumentList struct {
 	URL                  string `default:"https://vcenteripaddress/sdk" help:"vSphere or vCenter SDK URL"`
 	Username             string `default:"" help:"The vSphere or vCenter username."`
 	Password             string `default:"" help:"The vSphere or vCenter password."`
-	ConfigFile           string `default:"" help:"Config file containing list of metric names(overrides default config)"`
-	SourceConfig         int    `default:"9" help:"Undocumented"`
+	ConfigFile           string `default:"" help:"JSON or YAML file with the performance counters of each entity type (overrides default config)"`
+	SourceConfig         int    `default:"9" help:"Deprecated, use the *_mode arguments. Bitmask of the entity types collected from performance counters: host 1, vm 2, datastore 4, resource pool 8, cluster 16"`
 	Insecure             bool   `default:"true" help:"Don't verify the server's certificate chain"`
 	LogAvailableCounters bool   `default:"false" help:"[Trace] Log all available performance counters"`
+	EnableTags           bool   `default:"false" help:"Decorate samples with vSphere tags read from the vCenter tagging REST API"`
+	PerfBatchSize        int    `default:"50" help:"Number of entities whose performance metrics are queried in a single call"`
+	PerfInstanceMetrics  bool   `default:"false" help:"Also report the per instance values (vCPU, disk, NIC...) of performance counters that have an aggregate value as separate samples"`
+	AggregateSamples     bool   `default:"false" help:"Report the average, minimum and maximum of the real-time samples since the previous run instead of the latest sample"`
+	EntityPerObject      bool   `default:"false" help:"Report each host, virtual machine, datastore, resource pool and cluster as its own entity"`
+	Concurrency          int    `default:"4" help:"Number of entity types and performance queries collected in parallel"`
+	CounterCacheTTL      int    `default:"1440" help:"Minutes the performance counter catalog is cached on disk, 0 disables the cache"`
+	ValidateConfig       bool   `default:"false" help:"Check the configured counters against the counter catalog and exit"`
+	CounterCatalogFile   string `default:"" help:"Counter catalog file used by -validate_config instead of connecting to vCenter"`
+	ExportCounterCatalog string `default:"" help:"Write the counter catalog of vCenter to this file and exit"`
+	HostMode             string `default:"" help:"How host metrics are collected. {perf|summary|both|off}"`
+	VMMode               string `default:"" help:"How virtual machine metrics are collected. {perf|summary|both|off}"`
+	ResourcePoolMode     string `default:"" help:"How resource pool metrics are collected. {perf|summary|both|off}"`
+	DatastoreMode        string `default:"" help:"How datastore metrics are collected. {perf|summary|both|off}"`
+	ClusterMode          string `default:"" help:"How cluster metrics are collected. {perf|summary|both|off}"`
+	NormalizeUnits       bool   `default:"false" help:"Scale performance counter values to their base unit (percent, bytes, bytes per second) and append the unit to the metric name"`
 }
 
 const (
 	integrationName              = "com.newrelic.vmware-esxi"
 	integrationVersion           = "1.0.7"
-	bitHostSystemPerfMetrics     = 1 // get performance metrics for host system
-	bitVirtualMachinePerfMetrics = 2 // get performance metrics for vm
-	bitDatastorePerfMetrics      = 4 // get performance metrics for datastore
-	bitResourcePoolPerfMetrics   = 8 // get performance metrics for resource pool
+	bitHostSystemPerfMetrics     = 1  // get performance metrics for host system
+	bitVirtualMachinePerfMetrics = 2  // get performance metrics for vm
+	bitDatastorePerfMetrics      = 4  // get performance metrics for datastore
+	bitResourcePoolPerfMetrics   = 8  // get performance metrics for resource pool
+	bitClusterPerfMetrics        = 16 // get performance metrics for cluster compute resource
 )
 
 var (
@@ -37,11 +54,16 @@ var (
 	vmCounters    []string
 	rpoolCounters []string
 	dsCounters    []string
+	clCounters    []string
 
-	enableHostSystemPerfMetrics     = true
-	enableVirtualMachinePerfMetrics = true
-	enableDatastorePerfMetrics      = true
-	enableResourcePoolPerfMetrics   = true
+	configuredFilters entityFilters
+	configuredModes   collectionModes
+
+	hostMode  collectionMode
+	vmMode    collectionMode
+	rpoolMode collectionMode
+	dsMode    collectionMode
+	clMode    collectionMode
 )
 
 func main() {
@@ -60,35 +82,13 @@ func main() {
 	password := strings.TrimSpace(args.Password)
 	validateSSL := true
 
-	//
-	sourceConfig := args.SourceConfig
-	if (sourceConfig & bitHostSystemPerfMetrics) != 0 {
-		enableHostSystemPerfMetrics = true
-	} else {
-		enableHostSystemPerfMetrics = false
-	}
-	if (sourceConfig & bitVirtualMachinePerfMetrics) != 0 {
-		enableVirtualMachinePerfMetrics = true
-	} else {
-		enableVirtualMachinePerfMetrics = false
-	}
-	if (sourceConfig & bitDatastorePerfMetrics) != 0 {
-		enableDatastorePerfMetrics = true
-	} else {
-		enableDatastorePerfMetrics = false
-	}
-	if (sourceConfig & bitResourcePoolPerfMetrics) != 0 {
-		enableResourcePoolPerfMetrics = true
-	} else {
-		enableResourcePoolPerfMetrics = false
-	}
-
 	if configFile == "" {
 		//use defaults from metrics_definition.go
 		hostCounters = defaultHostCounters
 		vmCounters = defaultVMCounters
-		rpoolCounters = []string{}
-		dsCounters = []string{}
+		rpoolCounters = defaultResourcePoolCounters
+		dsCounters = defaultDatastoreCounters
+		clCounters = defaultClusterCounters
 	} else {
 		err = parseConfigFile(configFile)
 		if err != nil {
@@ -96,6 +96,23 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	err = resolveCollectionModes(configuredModes)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if args.ExportCounterCatalog != "" {
+		err = exportCounterCatalog(url, username, password, validateSSL, args.ExportCounterCatalog)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(3)
+		}
+		os.Exit(0)
+	}
+	if args.ValidateConfig {
+		os.Exit(validateConfig(url, username, password, validateSSL))
+	}
 
 	// Connect and login to ESXi host or vCenter
 	client, err := newClient(url, username, password, validateSSL)
@@ -106,7 +123,17 @@ func main() {
 	}
 	defer logout(client)
 
-	err = populateMetricsAndInventory(i, client, datacenter)
+	var tags *tagClient
+	if args.EnableTags && (args.All() || args.Metrics || (args.Inventory && configuredFilters.configured())) {
+		tags, err = newTagClient(url, username, password, args.Insecure)
+		if err != nil {
+			log.Error(err.Error())
+		} else {
+			defer tags.logout()
+		}
+	}
+
+	err = populateMetricsAndInventory(i, client, tags, datacenter)
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(2)
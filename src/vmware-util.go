@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
-	"io"
+	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/vim25/soap"
 )
 
+// storeTTL is how long state kept between runs stays valid
+const storeTTL = 24 * time.Hour
+
 func setCredentials(u *url.URL, un string, pw string) {
 	// Override username if provided
 	if un != "" {
@@ -55,13 +60,6 @@ func newClient(vmURL string, vmUsername string, vmPassword string, validateSSL b
 	return govmomi.NewClient(ctx, url, validateSSL)
 }
 
-func close(c io.Closer) {
-	err := c.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 func logout(client *govmomi.Client) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -70,3 +68,11 @@ func logout(client *govmomi.Client) {
 		log.Error(err.Error())
 	}
 }
+
+// newStore opens the on-disk store used to keep state between runs. Stores are
+// kept per vCenter so that several instances of the integration do not share state.
+// Values older than ttl are discarded.
+func newStore(client *govmomi.Client, name string, ttl time.Duration) (persist.Storer, error) {
+	path := persist.DefaultPath(fmt.Sprintf("%s.%s.%s", integrationName, name, client.URL().Hostname()))
+	return persist.NewFileStore(path, log.NewStdErr(args.Verbose), ttl)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "sync"
+
+// workerPool bounds the number of tasks running at the same time
+type workerPool struct {
+	slots chan struct{}
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &workerPool{slots: make(chan struct{}, size)}
+}
+
+// run waits for a free slot and runs task in its own goroutine, marking it done on wg when it returns
+func (p *workerPool) run(wg *sync.WaitGroup, task func()) {
+	wg.Add(1)
+	p.slots <- struct{}{}
+	go func() {
+		defer func() {
+			<-p.slots
+			wg.Done()
+		}()
+		task()
+	}()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPool(t *testing.T) {
+	pool := newWorkerPool(2)
+	var wg sync.WaitGroup
+	var running, maxRunning, done int32
+	for i := 0; i < 10; i++ {
+		pool.run(&wg, func() {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&done, 1)
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(10), done)
+	assert.True(t, maxRunning <= 2)
+}